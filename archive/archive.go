@@ -0,0 +1,474 @@
+// Package archive saves self-contained copies of bookmarked pages
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/valueof/bland/metadata"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// MAX_PAGE_SIZE is how much of the page itself is read
+const MAX_PAGE_SIZE = 5 << 20
+
+// MAX_RESOURCE_SIZE is the largest stylesheet, image or font that is inlined
+const MAX_RESOURCE_SIZE = 2 << 20
+
+// MAX_RESOURCES caps how many resources are fetched for a page
+const MAX_RESOURCES = 150
+
+// MAX_SNAPSHOT_SIZE caps the size of the copy before compression
+const MAX_SNAPSHOT_SIZE = 25 << 20
+
+// CAPTURE_TIMEOUT is how long archiving a page may take in total
+const CAPTURE_TIMEOUT = 2 * time.Minute
+
+// MAX_IMPORT_DEPTH is how deep @import is followed
+const MAX_IMPORT_DEPTH = 3
+
+var ErrNotHTML = errors.New("not an HTML page")
+
+var CSS_URL_RE *regexp.Regexp = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)`)
+var CSS_IMPORT_RE *regexp.Regexp = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"')\s;]+)["']?\s*\)?\s*([^;]*);`)
+
+// REMOVED_ELEMENTS are dropped from the copy along with their contents
+var REMOVED_ELEMENTS = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Base:     true,
+	atom.Template: true,
+}
+
+// INLINED_TYPES are the media types resources are inlined as
+var INLINED_TYPES = []string{"image/", "font/", "application/font-", "application/x-font-", "application/vnd.ms-fontobject"}
+
+var BANNER = template.Must(template.New("banner").Parse(`<div style="all: initial; display: block; font: 13px/1.4 sans-serif; padding: 8px 12px; background: #fff5ca; color: #000; border-bottom: 1px solid #d9cc8f">
+Archived copy of <a style="color: #000" href="{{.URL}}">{{.URL}}</a> saved on {{.Date}}.
+</div>`))
+
+type Snapshot struct {
+	// URL is where the page was archived from after redirects
+	URL string
+
+	// Data is the gzipped HTML of the copy
+	Data []byte
+}
+
+// Capture downloads the page at raw and returns a self-contained copy
+func Capture(ctx context.Context, raw string) (*Snapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, CAPTURE_TIMEOUT)
+	defer cancel()
+
+	resp, err := metadata.Get(ctx, raw, MAX_PAGE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ContentType != "" {
+		mediatype, _, err := mime.ParseMediaType(resp.ContentType)
+		if err != nil || (mediatype != "text/html" && mediatype != "application/xhtml+xml") {
+			return nil, ErrNotHTML
+		}
+	}
+
+	r, err := charset.NewReader(bytes.NewReader(resp.Body), resp.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse <noscript> as markup since scripts never run in the copy
+	doc, err := html.ParseWithOptions(r, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &capture{
+		ctx:       ctx,
+		resources: map[string]string{},
+		size:      int64(len(resp.Body)),
+	}
+	c.walk(doc, resp.URL)
+	c.addHeader(doc, resp.URL)
+
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if err := html.Render(zw, doc); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{URL: resp.URL.String(), Data: buf.Bytes()}, nil
+}
+
+type capture struct {
+	ctx context.Context
+
+	// resources maps URLs fetched so far to data: URLs, or ""
+	resources map[string]string
+	fetched   int
+	size      int64
+}
+
+// fetch downloads a resource unless the limits have been reached
+func (c *capture) fetch(u string) (*metadata.Response, bool) {
+	if c.fetched >= MAX_RESOURCES || c.size >= MAX_SNAPSHOT_SIZE {
+		return nil, false
+	}
+	c.fetched++
+
+	resp, err := metadata.Get(c.ctx, u, MAX_RESOURCE_SIZE+1)
+	if err != nil || len(resp.Body) > MAX_RESOURCE_SIZE {
+		return nil, false
+	}
+
+	c.size += int64(len(resp.Body))
+	return resp, true
+}
+
+// dataURL returns the resource at u as a data: URL, or ""
+func (c *capture) dataURL(u string) string {
+	if v, ok := c.resources[u]; ok {
+		return v
+	}
+	c.resources[u] = ""
+
+	resp, ok := c.fetch(u)
+	if !ok {
+		return ""
+	}
+
+	mediatype, _, _ := mime.ParseMediaType(resp.ContentType)
+	if mediatype == "" || mediatype == "application/octet-stream" || mediatype == "text/plain" {
+		mediatype, _, _ = mime.ParseMediaType(http.DetectContentType(resp.Body))
+	}
+
+	for _, prefix := range INLINED_TYPES {
+		if strings.HasPrefix(mediatype, prefix) {
+			v := "data:" + mediatype + ";base64," + base64.StdEncoding.EncodeToString(resp.Body)
+			c.resources[u] = v
+			return v
+		}
+	}
+
+	return ""
+}
+
+// stylesheet returns the stylesheet at u with everything inlined
+func (c *capture) stylesheet(u *url.URL, depth int) (string, bool) {
+	resp, ok := c.fetch(u.String())
+	if !ok {
+		return "", false
+	}
+
+	mediatype, _, _ := mime.ParseMediaType(resp.ContentType)
+	if mediatype != "" && mediatype != "text/css" && mediatype != "text/plain" {
+		return "", false
+	}
+
+	return c.css(string(resp.Body), resp.URL, depth), true
+}
+
+// css inlines the imports and url()s of a stylesheet
+func (c *capture) css(s string, base *url.URL, depth int) string {
+	s = CSS_IMPORT_RE.ReplaceAllStringFunc(s, func(m string) string {
+		parts := CSS_IMPORT_RE.FindStringSubmatch(m)
+		u, err := base.Parse(parts[1])
+		if err != nil || depth >= MAX_IMPORT_DEPTH {
+			return ""
+		}
+
+		imported, ok := c.stylesheet(u, depth+1)
+		if !ok {
+			return ""
+		}
+
+		if media := strings.TrimSpace(parts[2]); media != "" {
+			return fmt.Sprintf("@media %s {\n%s\n}", media, imported)
+		}
+		return imported
+	})
+
+	return CSS_URL_RE.ReplaceAllStringFunc(s, func(m string) string {
+		parts := CSS_URL_RE.FindStringSubmatch(m)
+		ref := strings.TrimSpace(parts[1] + parts[2] + parts[3])
+		if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+			return m
+		}
+
+		u, err := base.Parse(ref)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return "url()"
+		}
+
+		if v := c.dataURL(u.String()); v != "" {
+			return fmt.Sprintf(`url("%s")`, v)
+		}
+		return "url()"
+	})
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, name) {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, name, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, name) {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: name, Val: value})
+}
+
+func removeAttrs(n *html.Node, remove func(key string) bool) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if !remove(strings.ToLower(a.Key)) {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
+
+// resolve returns ref as an absolute http(s) URL, or ""
+func resolve(base *url.URL, ref string) string {
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto") {
+		return ""
+	}
+	return u.String()
+}
+
+// walk rewrites the document in place
+func (c *capture) walk(n *html.Node, base *url.URL) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+
+		switch {
+		case child.Type == html.CommentNode:
+			n.RemoveChild(child)
+
+		case child.Type != html.ElementNode:
+
+		case REMOVED_ELEMENTS[child.DataAtom] || !c.element(child, base):
+			n.RemoveChild(child)
+
+		case child.DataAtom == atom.Noscript:
+			// Scripts never run, so show what the page has instead
+			c.walk(child, base)
+			for gc := child.FirstChild; gc != nil; {
+				gcNext := gc.NextSibling
+				child.RemoveChild(gc)
+				n.InsertBefore(gc, child)
+				gc = gcNext
+			}
+			n.RemoveChild(child)
+
+		default:
+			c.walk(child, base)
+		}
+
+		child = next
+	}
+}
+
+// element rewrites an element and reports whether to keep it
+func (c *capture) element(n *html.Node, base *url.URL) bool {
+	removeAttrs(n, func(key string) bool {
+		return strings.HasPrefix(key, "on") || key == "srcset" || key == "sizes" || key == "integrity"
+	})
+
+	if style := attr(n, "style"); style != "" {
+		setAttr(n, "style", c.css(style, base, 0))
+	}
+
+	switch n.DataAtom {
+	case atom.Meta:
+		switch strings.ToLower(attr(n, "http-equiv")) {
+		case "refresh", "content-security-policy", "set-cookie", "content-type":
+			return false
+		}
+		return attr(n, "charset") == ""
+
+	case atom.Link:
+		return c.link(n, base)
+
+	case atom.Style:
+		if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+			n.FirstChild.Data = c.css(n.FirstChild.Data, base, 0)
+		}
+
+	case atom.Source:
+		// <picture> falls back to its <img>, media sources stay links
+		if n.Parent != nil && n.Parent.DataAtom == atom.Picture {
+			return false
+		}
+		if src := attr(n, "src"); src != "" {
+			setAttr(n, "src", resolve(base, src))
+		}
+
+	case atom.Img, atom.Input:
+		src := firstNonEmpty(attr(n, "data-src"), attr(n, "data-lazy-src"), attr(n, "data-original"), attr(n, "src"))
+		if src == "" || strings.HasPrefix(src, "data:") {
+			break
+		}
+
+		if u := resolve(base, src); u != "" {
+			setAttr(n, "src", firstNonEmpty(c.dataURL(u), u))
+		}
+
+	case atom.A, atom.Area:
+		href := attr(n, "href")
+		if href == "" || strings.HasPrefix(href, "#") {
+			break
+		}
+
+		if u := resolve(base, href); u != "" {
+			setAttr(n, "href", u)
+		} else {
+			removeAttrs(n, func(key string) bool { return key == "href" })
+		}
+
+	case atom.Form:
+		setAttr(n, "action", resolve(base, attr(n, "action")))
+
+	case atom.Video, atom.Audio:
+		if src := attr(n, "src"); src != "" {
+			setAttr(n, "src", resolve(base, src))
+		}
+		if poster := attr(n, "poster"); poster != "" {
+			if u := resolve(base, poster); u != "" {
+				setAttr(n, "poster", firstNonEmpty(c.dataURL(u), u))
+			}
+		}
+	}
+
+	return true
+}
+
+// link inlines stylesheets and icons and drops every other kind of link
+func (c *capture) link(n *html.Node, base *url.URL) bool {
+	href := resolve(base, attr(n, "href"))
+	if href == "" {
+		return false
+	}
+
+	for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+		switch rel {
+		case "stylesheet":
+			u, _ := url.Parse(href)
+			css, ok := c.stylesheet(u, 0)
+			if !ok {
+				return false
+			}
+
+			n.Data = "style"
+			n.DataAtom = atom.Style
+			media := attr(n, "media")
+			n.Attr = nil
+			if media != "" {
+				setAttr(n, "media", media)
+			}
+			// Don't let the stylesheet close the element
+			css = strings.ReplaceAll(css, "</", `<\/`)
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+			return true
+
+		case "icon", "canonical":
+			if rel == "icon" {
+				href = c.dataURL(href)
+			}
+			n.Attr = []html.Attribute{{Key: "rel", Val: rel}, {Key: "href", Val: href}}
+			return href != ""
+		}
+	}
+
+	return false
+}
+
+// addHeader declares the copy as UTF-8 and adds a banner saying where and
+// when it was archived from
+func (c *capture) addHeader(doc *html.Node, from *url.URL) {
+	var head, body *html.Node
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Head:
+				head = n
+			case atom.Body:
+				body = n
+			}
+		}
+		for c := n.FirstChild; c != nil && (head == nil || body == nil); c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	if head != nil {
+		meta := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "meta",
+			DataAtom: atom.Meta,
+			Attr:     []html.Attribute{{Key: "charset", Val: "utf-8"}},
+		}
+		head.InsertBefore(meta, head.FirstChild)
+	}
+
+	if body == nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	BANNER.Execute(buf, map[string]string{
+		"URL":  from.String(),
+		"Date": time.Now().UTC().Format("January 2, 2006"),
+	})
+
+	nodes, err := html.ParseFragment(buf, body)
+	if err != nil {
+		return
+	}
+
+	for i := len(nodes) - 1; i >= 0; i-- {
+		body.InsertBefore(nodes[i], body.FirstChild)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ARCHIVE_PENDING = "pending"
+	ARCHIVE_DONE    = "done"
+	ARCHIVE_FAILED  = "failed"
+)
+
+type Archive struct {
+	BookmarkID int64
+	UserID     int64
+	Status     string
+
+	// URL is where the page was archived from, after redirects
+	URL string
+
+	// Data is the gzipped HTML of the page
+	Data        []byte
+	Size        int64
+	Error       string
+	RequestedAt int64
+	ArchivedAt  int64
+}
+
+func (a *Archive) TimeArchived() *time.Time {
+	tm := time.Unix(a.ArchivedAt, 0)
+	return &tm
+}
+
+// RequestArchive queues a bookmark to be archived
+func (tx *Tx) RequestArchive(bookmarkID int64) (err error) {
+	q := fmt.Sprintf(`
+	insert into archives (bookmark_id, user_id, status, requested_at)
+	select id, user_id, ?, ? from bookmarks where id = ? and deleted_at = 0 and %s
+	on conflict (bookmark_id) do update set
+		status = excluded.status,
+		error = '',
+		requested_at = excluded.requested_at
+	`, tx.owned())
+
+	res, err := tx.sqlTx.Exec(q, ARCHIVE_PENDING, time.Now().Unix(), bookmarkID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.EnqueueJob(JOB_ARCHIVE, fmt.Sprint(bookmarkID), BookmarkJob{BookmarkID: bookmarkID})
+	return err
+}
+
+// FetchArchiveRequest returns the archive of a bookmark that isn't in the
+// trash, without Data and with the bookmark's current URL
+func FetchArchiveRequest(bookmarkID int64) (a *Archive, err error) {
+	a = &Archive{}
+	q := `
+	select a.bookmark_id, a.user_id, a.status, b.url, a.size, a.error, a.requested_at, a.archived_at
+	from archives a
+	join bookmarks b on b.id = a.bookmark_id
+	where a.bookmark_id = ? and b.deleted_at = 0
+	`
+	err = db.QueryRow(q, bookmarkID).Scan(&a.BookmarkID, &a.UserID, &a.Status, &a.URL, &a.Size, &a.Error, &a.RequestedAt, &a.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func FetchArchive(bookmarkID int64) (a *Archive, err error) {
+	a = &Archive{}
+	q := `
+	select bookmark_id, user_id, status, url, data, size, error, requested_at, archived_at
+	from archives
+	where bookmark_id = ?
+	`
+	err = db.QueryRow(q, bookmarkID).Scan(&a.BookmarkID, &a.UserID, &a.Status, &a.URL, &a.Data, &a.Size, &a.Error, &a.RequestedAt, &a.ArchivedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchArchive: %v\n", err)
+		}
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// SaveArchive stores a new copy of the page, replacing the previous one
+func (tx *Tx) SaveArchive(a Archive) (err error) {
+	q := `
+	update archives
+	set status = ?, url = ?, data = ?, size = ?, error = '', archived_at = ?
+	where bookmark_id = ?
+	`
+	_, err = tx.sqlTx.Exec(q, ARCHIVE_DONE, a.URL, a.Data, len(a.Data), time.Now().Unix(), a.BookmarkID)
+	return
+}
+
+// FailArchive records why a page couldn't be archived, keeping any
+// previous copy
+func (tx *Tx) FailArchive(bookmarkID int64, reason string) (err error) {
+	q := `update archives set status = ?, error = ? where bookmark_id = ?`
+	_, err = tx.sqlTx.Exec(q, ARCHIVE_FAILED, reason, bookmarkID)
+	return
+}
+
+// ArchiveUsage returns how many bytes of archives a user keeps, not
+// counting the given bookmark's
+func ArchiveUsage(userID, except int64) (size int64, err error) {
+	q := `select coalesce(sum(size), 0) from archives where user_id = ? and bookmark_id <> ?`
+	err = db.QueryRow(q, userID, except).Scan(&size)
+	return
+}
+
+// FetchArchiveQuota returns how many bytes of archives a user can keep
+func FetchArchiveQuota(userID int64) (quota int64, err error) {
+	err = db.QueryRow(`select archive_quota from users where id = ?`, userID).Scan(&quota)
+	return
+}
+
+// SetArchiveQuota changes the archive quota of the user the transaction
+// acts for
+func (tx *Tx) SetArchiveQuota(quota int64) (err error) {
+	if tx.userID == 0 {
+		return errors.New("archive quotas need a user")
+	}
+
+	if quota < 0 {
+		return errors.New("archive quota can't be negative")
+	}
+
+	_, err = tx.sqlTx.Exec(`update users set archive_quota = ? where id = ?`, quota, tx.userID)
+	return
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// WORDS_PER_MINUTE is the reading speed reading times are estimated with
+const WORDS_PER_MINUTE = 230
+
+// Article is the readable text of a bookmarked page
+type Article struct {
+	BookmarkID int64
+
+	// URL is what the bookmark pointed at when the text was extracted
+	URL string
+
+	// Content is the article as simplified, already escaped HTML
+	Content     string
+	Text        string
+	WordCount   int
+	Error       string
+	ExtractedAt int64
+}
+
+func (a *Article) HTML() template.HTML {
+	return template.HTML(a.Content)
+}
+
+func (a *Article) ReadingTime() int {
+	return readingTime(a.WordCount)
+}
+
+func readingTime(words int) int {
+	if words == 0 {
+		return 0
+	}
+	return (words + WORDS_PER_MINUTE - 1) / WORDS_PER_MINUTE
+}
+
+// BookmarksWithoutArticle returns up to limit bookmarks whose text hasn't
+// been extracted yet, newest first so that new bookmarks don't wait for
+// an import to be worked through
+func BookmarksWithoutArticle(limit int) (bookmarks []Bookmark, err error) {
+	q := fmt.Sprintf(`
+	select %s
+	from bookmarks b
+	left join articles a on a.bookmark_id = b.id
+	where a.bookmark_id is null and b.deleted_at = 0
+	order by b.created_at desc
+	limit ?
+	`, BOOKMARK_COLUMNS)
+	return fetchBookmarks(q, limit)
+}
+
+func FetchArticle(bookmarkID int64) (a *Article, err error) {
+	a = &Article{}
+	q := `
+	select bookmark_id, url, content, text, word_count, error, extracted_at
+	from articles
+	where bookmark_id = ?
+	`
+	err = db.QueryRow(q, bookmarkID).Scan(&a.BookmarkID, &a.URL, &a.Content, &a.Text, &a.WordCount, &a.Error, &a.ExtractedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchArticle: %v\n", err)
+		}
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// SaveArticle stores the text of a bookmark, or with Error set, why there
+// isn't any
+func (tx *Tx) SaveArticle(a Article) (err error) {
+	if a.ExtractedAt == 0 {
+		a.ExtractedAt = time.Now().Unix()
+	}
+
+	q := `
+	insert into articles (bookmark_id, url, content, text, word_count, error, extracted_at)
+	values (?, ?, ?, ?, ?, ?, ?)
+	on conflict (bookmark_id) do update set
+		url = excluded.url,
+		content = excluded.content,
+		text = excluded.text,
+		word_count = excluded.word_count,
+		error = excluded.error,
+		extracted_at = excluded.extracted_at
+	`
+	_, err = tx.sqlTx.Exec(q, a.BookmarkID, a.URL, a.Content, a.Text, a.WordCount, a.Error, a.ExtractedAt)
+	return
+}
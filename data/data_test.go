@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB points the package at a fresh in-memory database with every
+// migration applied. Search needs FTS5, so without -tags sqlite_fts5 the
+// test is skipped.
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	var err error
+	db, err = sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every connection to :memory: gets its own empty database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob(filepath.Join("..", "sql", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		script, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.Exec(string(script)); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				t.Skip("needs -tags sqlite_fts5")
+			}
+			t.Fatalf("%s: %v", f, err)
+		}
+	}
+}
+
+// addTestBookmarks adds bookmarks for nobody in particular and returns
+// their ids
+func addTestBookmarks(t *testing.T, bookmarks ...Bookmark) (ids []int64) {
+	t.Helper()
+
+	tx, err := BeginTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range bookmarks {
+		id, err := tx.AddBookmark(b)
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("AddBookmark(%s): %v", b.URL, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	return ids
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FAVICON_RETRY_AFTER is how long to wait before looking for the icon of
+// a host again when it didn't have one last time
+const FAVICON_RETRY_AFTER = 7 * 24 * time.Hour
+
+type Favicon struct {
+	Host        string
+	ContentType string
+	Data        []byte
+	FetchedAt   int64
+}
+
+// HostOf returns the lowercase host of a URL, including the port if it
+// has one, or "" if it doesn't have a host
+func HostOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// FetchFavicon returns the stored icon of host. Data is empty if the host
+// was checked and didn't have one.
+func FetchFavicon(host string) (f *Favicon, err error) {
+	f = &Favicon{}
+	q := `select host, content_type, data, fetched_at from favicons where host = ?`
+	err = db.QueryRow(q, host).Scan(&f.Host, &f.ContentType, &f.Data, &f.FetchedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchFavicon: %v\n", err)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (tx *Tx) SaveFavicon(f Favicon) (err error) {
+	if f.FetchedAt == 0 {
+		f.FetchedAt = time.Now().Unix()
+	}
+
+	q := `
+	insert into favicons (host, content_type, data, fetched_at)
+	values (?, ?, ?, ?)
+	on conflict (host) do update set
+		content_type = excluded.content_type,
+		data = excluded.data,
+		fetched_at = excluded.fetched_at
+	`
+	_, err = tx.sqlTx.Exec(q, f.Host, f.ContentType, f.Data, f.FetchedAt)
+	return
+}
+
+// HostsWithoutFavicon returns up to limit hosts of saved bookmarks that
+// were never checked for an icon or didn't have one the last time they
+// were checked, longer than FAVICON_RETRY_AFTER ago
+func HostsWithoutFavicon(limit int) (hosts []string, err error) {
+	known := map[string]bool{}
+	retryBefore := time.Now().Add(-FAVICON_RETRY_AFTER).Unix()
+
+	rows, err := db.Query(`select host, coalesce(length(data), 0) > 0, fetched_at from favicons`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var host string
+		var hasIcon bool
+		var fetchedAt int64
+		if err = rows.Scan(&host, &hasIcon, &fetchedAt); err != nil {
+			return nil, err
+		}
+		known[host] = hasIcon || fetchedAt >= retryBefore
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	urls, err := db.Query(`select distinct url from bookmarks where deleted_at = 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer urls.Close()
+
+	seen := map[string]bool{}
+	for urls.Next() {
+		var raw string
+		if err = urls.Scan(&raw); err != nil {
+			return nil, err
+		}
+
+		host := HostOf(raw)
+		if host != "" && !known[host] && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	if err = urls.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(hosts)
+	if len(hosts) > limit {
+		hosts = hosts[:limit]
+	}
+
+	return hosts, nil
+}
+
+// HostIsVisible reports whether any bookmark in the scope points at host,
+// so that icons don't give away which sites someone bookmarked privately
+func HostIsVisible(s Scope, host string) (ok bool, err error) {
+	where := []string{
+		"b.deleted_at = 0",
+		"(b.url like ? or b.url like ? or b.url like ? or b.url like ?)",
+	}
+	where = append(where, s.where()...)
+
+	q := fmt.Sprintf(`select exists (select 1 from bookmarks b where %s)`, strings.Join(where, " and "))
+	err = db.QueryRow(q,
+		"%://"+host,
+		"%://"+host+"/%",
+		"%://"+host+"?%",
+		"%://"+host+"#%").Scan(&ok)
+	return
+}
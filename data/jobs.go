@@ -0,0 +1,379 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	JOB_PENDING = "pending"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+const (
+	JOB_METADATA  = "metadata"
+	JOB_FAVICON   = "favicon"
+	JOB_ARCHIVE   = "archive"
+	JOB_ARTICLE   = "article"
+	JOB_LINKCHECK = "linkcheck"
+)
+
+// JOB_OPTIONS says how many times each kind of job is tried and which
+// go first
+var JOB_OPTIONS = map[string]struct {
+	Priority    int
+	MaxAttempts int
+}{
+	JOB_METADATA: {10, 1},
+	JOB_FAVICON:  {0, 3},
+	JOB_ARCHIVE:  {0, 5},
+	JOB_ARTICLE:  {0, 5},
+
+	// Checking every link is never urgent
+	JOB_LINKCHECK: {-10, 3},
+}
+
+type Job struct {
+	ID          int64  `json:"id"`
+	UserID      int64  `json:"-"`
+	Kind        string `json:"kind"`
+	Key         string `json:"-"`
+	Payload     string `json:"-"`
+	Status      string `json:"status"`
+	Priority    int    `json:"-"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"maxAttempts"`
+	RunAt       int64  `json:"runAt"`
+	LastError   string `json:"error"`
+
+	// Result is the JSON a job returned when it's done
+	Result    string `json:"-"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+const JOB_COLUMNS = `
+	id,
+	user_id,
+	kind,
+	key,
+	payload,
+	status,
+	priority,
+	attempts,
+	max_attempts,
+	run_at,
+	last_error,
+	result,
+	created_at,
+	updated_at`
+
+func jobFields(j *Job) []any {
+	return []any{
+		&j.ID,
+		&j.UserID,
+		&j.Kind,
+		&j.Key,
+		&j.Payload,
+		&j.Status,
+		&j.Priority,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.RunAt,
+		&j.LastError,
+		&j.Result,
+		&j.CreatedAt,
+		&j.UpdatedAt,
+	}
+}
+
+// LastAttempt reports whether the job won't be retried if it fails
+func (j *Job) LastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// Subject describes what the job works on, for people to read
+func (j *Job) Subject() string {
+	switch j.Kind {
+	case JOB_ARCHIVE, JOB_ARTICLE, JOB_LINKCHECK:
+		return "bookmark " + j.Key
+	case JOB_METADATA:
+		var p MetadataJob
+		json.Unmarshal([]byte(j.Payload), &p)
+		return p.URL
+	}
+	return j.Key
+}
+
+func (j *Job) TimeRunAt() *time.Time {
+	tm := time.Unix(j.RunAt, 0)
+	return &tm
+}
+
+func (j *Job) TimeUpdated() *time.Time {
+	tm := time.Unix(j.UpdatedAt, 0)
+	return &tm
+}
+
+// EnqueueJob adds a job on behalf of the user the transaction acts for,
+// or returns the id of a queued one with the same kind and non-empty key
+func (tx *Tx) EnqueueJob(kind, key string, payload any) (id int64, err error) {
+	opts, ok := JOB_OPTIONS[kind]
+	if !ok {
+		return 0, fmt.Errorf("unknown job kind %q", kind)
+	}
+
+	p, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	q1 := `
+	insert or ignore into jobs (user_id, kind, key, payload, status, priority, max_attempts, run_at, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	res, err := tx.sqlTx.Exec(q1, tx.userID, kind, key, string(p), JOB_PENDING, opts.Priority, opts.MaxAttempts, now, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return res.LastInsertId()
+	}
+
+	// A running job may already be working with outdated data
+	q2 := `update jobs set rerun = 1 where kind = ? and key = ? and status = ?`
+	if _, err = tx.sqlTx.Exec(q2, kind, key, JOB_RUNNING); err != nil {
+		return 0, err
+	}
+
+	q3 := `select id from jobs where kind = ? and key = ? and status in (?, ?)`
+	err = tx.sqlTx.QueryRow(q3, kind, key, JOB_PENDING, JOB_RUNNING).Scan(&id)
+	return id, err
+}
+
+// QueueBookmarkJobs queues the background work for a new bookmark
+func (tx *Tx) QueueBookmarkJobs(id int64) (err error) {
+	if err = tx.RequestArchive(id); err != nil {
+		return err
+	}
+
+	if _, err = tx.EnqueueJob(JOB_ARTICLE, fmt.Sprint(id), BookmarkJob{BookmarkID: id}); err != nil {
+		return err
+	}
+
+	var raw string
+	if err = tx.sqlTx.QueryRow(`select url from bookmarks where id = ?`, id).Scan(&raw); err != nil {
+		return err
+	}
+
+	host := HostOf(raw)
+	if host == "" {
+		return nil
+	}
+
+	var known bool
+	if err = tx.sqlTx.QueryRow(`select exists (select 1 from favicons where host = ?)`, host).Scan(&known); err != nil || known {
+		return err
+	}
+
+	_, err = tx.EnqueueJob(JOB_FAVICON, host, FaviconJob{Host: host})
+	return err
+}
+
+// BookmarkJob is the payload of jobs that work on a single bookmark
+type BookmarkJob struct {
+	BookmarkID int64 `json:"bookmarkId"`
+}
+
+type FaviconJob struct {
+	Host string `json:"host"`
+}
+
+type MetadataJob struct {
+	URL string `json:"url"`
+}
+
+// ClaimJob marks the most urgent job that's due as running and returns
+// it, or sql.ErrNoRows
+func ClaimJob() (j *Job, err error) {
+	now := time.Now().Unix()
+	q := fmt.Sprintf(`
+	update jobs
+	set status = ?, attempts = attempts + 1, updated_at = ?
+	where id = (
+		select id from jobs
+		where status = ? and run_at <= ?
+		order by priority desc, run_at, id
+		limit 1
+	)
+	returning %s
+	`, JOB_COLUMNS)
+
+	j = &Job{}
+	err = db.QueryRow(q, JOB_RUNNING, now, JOB_PENDING, now).Scan(jobFields(j)...)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// FinishJob marks a job as done with the JSON it returned
+func (tx *Tx) FinishJob(id int64, result string) (err error) {
+	q := `update jobs set status = ?, result = ?, last_error = '', updated_at = ? where id = ?`
+	_, err = tx.sqlTx.Exec(q, JOB_DONE, result, time.Now().Unix(), id)
+	return
+}
+
+// RetryJob records why a job failed and queues it again at runAt
+func (tx *Tx) RetryJob(id int64, reason string, runAt int64) (err error) {
+	q := `update jobs set status = ?, last_error = ?, run_at = ?, updated_at = ? where id = ?`
+	_, err = tx.sqlTx.Exec(q, JOB_PENDING, reason, runAt, time.Now().Unix(), id)
+	return
+}
+
+// FailJob records why a job failed for good
+func (tx *Tx) FailJob(id int64, reason string) (err error) {
+	q := `update jobs set status = ?, last_error = ?, updated_at = ? where id = ?`
+	_, err = tx.sqlTx.Exec(q, JOB_FAILED, reason, time.Now().Unix(), id)
+	return
+}
+
+// RequeueJob puts a job that was queued again while running back in the
+// queue
+func (tx *Tx) RequeueJob(id int64) (err error) {
+	now := time.Now().Unix()
+	q := `update jobs set status = ?, attempts = 0, rerun = 0, last_error = '', result = '', run_at = ?, updated_at = ? where id = ? and rerun = 1`
+	_, err = tx.sqlTx.Exec(q, JOB_PENDING, now, now, id)
+	return
+}
+
+// ReleaseJob puts a job back in the queue to run at runAt without
+// counting the attempt
+func (tx *Tx) ReleaseJob(id int64, runAt int64) (err error) {
+	q := `update jobs set status = ?, attempts = max(attempts - 1, 0), rerun = 0, run_at = ?, updated_at = ? where id = ? and status = ?`
+	_, err = tx.sqlTx.Exec(q, JOB_PENDING, runAt, time.Now().Unix(), id, JOB_RUNNING)
+	return
+}
+
+// ReleaseRunningJobs puts every running job back in the queue
+func (tx *Tx) ReleaseRunningJobs() (n int64, err error) {
+	q := `update jobs set status = ?, attempts = max(attempts - 1, 0), rerun = 0, updated_at = ? where status = ?`
+	res, err := tx.sqlTx.Exec(q, JOB_PENDING, time.Now().Unix(), JOB_RUNNING)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeFinishedJobs deletes jobs that were done, and failed metadata jobs,
+// before the given unix timestamp
+func (tx *Tx) PurgeFinishedJobs(before int64) (n int64, err error) {
+	q := `delete from jobs where updated_at < ? and (status = ? or (status = ? and kind = ?))`
+	res, err := tx.sqlTx.Exec(q, before, JOB_DONE, JOB_FAILED, JOB_METADATA)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FetchJob returns a job queued by the given user, or by anyone if
+// userID is 0
+func FetchJob(userID, id int64) (j *Job, err error) {
+	where := []string{"id = ?"}
+	if userID != 0 {
+		where = append(where, fmt.Sprintf("user_id = %d", userID))
+	}
+
+	q := fmt.Sprintf(`select %s from jobs where %s`, JOB_COLUMNS, strings.Join(where, " and "))
+	j = &Job{}
+	err = db.QueryRow(q, id).Scan(jobFields(j)...)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchJob: %v\n", err)
+		}
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// FetchJobsByStatus returns up to limit jobs with the given status
+func FetchJobsByStatus(status string, limit int) (jobs []Job, err error) {
+	order := "updated_at desc"
+	if status == JOB_PENDING {
+		order = "priority desc, run_at, id"
+	}
+
+	q := fmt.Sprintf(`select %s from jobs where status = ? order by %s limit ?`, JOB_COLUMNS, order)
+	rows, err := db.Query(q, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var j Job
+		if err = rows.Scan(jobFields(&j)...); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// CountJobs returns how many jobs there are with each status
+func CountJobs() (counts map[string]int, err error) {
+	counts = map[string]int{}
+	rows, err := db.Query(`select status, count(*) from jobs group by status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var n int
+		if err = rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// RetryFailedJob queues a failed job again with a fresh set of attempts
+func (tx *Tx) RetryFailedJob(id int64) (err error) {
+	now := time.Now().Unix()
+	q := `update jobs set status = ?, attempts = 0, run_at = ?, updated_at = ? where id = ? and status = ?`
+	res, err := tx.sqlTx.Exec(q, JOB_PENDING, now, now, id, JOB_FAILED)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteJob removes a job that isn't running
+func (tx *Tx) DeleteJob(id int64) (err error) {
+	res, err := tx.sqlTx.Exec(`delete from jobs where id = ? and status <> ?`, id, JOB_RUNNING)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
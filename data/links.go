@@ -0,0 +1,47 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// LINK_CHECK_INTERVAL is how often the link checker requests the URL of
+// every bookmark
+const LINK_CHECK_INTERVAL = 7 * 24 * time.Hour
+
+// LinkCheck is what the link checker found when it requested a URL
+type LinkCheck struct {
+	// URL is the URL that was checked, which the bookmark may have moved
+	// away from in the meantime
+	URL        string
+	StatusCode int
+
+	// FinalURL is where redirects ended up
+	FinalURL string
+	Error    string
+}
+
+// BookmarksToCheck returns up to limit bookmarks whose links haven't been
+// checked for LINK_CHECK_INTERVAL, the ones never checked first
+func BookmarksToCheck(limit int) (bookmarks []Bookmark, err error) {
+	q := fmt.Sprintf(`
+	select %s
+	from bookmarks b
+	where b.deleted_at = 0 and b.checked_at < ?
+	order by b.checked_at, b.id
+	limit ?
+	`, BOOKMARK_COLUMNS)
+	return fetchBookmarks(q, time.Now().Add(-LINK_CHECK_INTERVAL).Unix(), limit)
+}
+
+// SaveLinkCheck records what the link checker found on a bookmark unless
+// its URL was changed while it was being checked
+func (tx *Tx) SaveLinkCheck(bookmarkID int64, c LinkCheck) (err error) {
+	q := `
+	update bookmarks
+	set link_status = ?, link_url = ?, link_error = ?, checked_at = ?
+	where id = ? and url = ?
+	`
+	_, err = tx.sqlTx.Exec(q, c.StatusCode, c.FinalURL, c.Error, time.Now().Unix(), bookmarkID, c.URL)
+	return
+}
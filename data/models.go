@@ -1,6 +1,8 @@
 package data
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -19,6 +21,26 @@ type Bookmark struct {
 	UpdatedAt   int64  `json:"updatedAt"`
 	DeletedAt   int64  `json:"deletedAt"`
 	ReadAt      int64  `json:"readAt"`
+	IsPrivate   bool   `json:"isPrivate"`
+
+	// ArchivedAt is when the last archived copy of the page was saved, 0
+	// if there isn't one
+	ArchivedAt int64 `json:"archivedAt"`
+
+	// WordCount is the length of the page's readable text, 0 if it hasn't
+	// been extracted or there wasn't any
+	WordCount int `json:"wordCount"`
+
+	// LinkStatus, LinkURL and LinkError are what the link checker found
+	// when it last requested the URL, at CheckedAt: the status of the
+	// response and where redirects ended up, or why there was no response
+	LinkStatus int    `json:"linkStatus"`
+	LinkURL    string `json:"linkUrl"`
+	LinkError  string `json:"linkError"`
+	CheckedAt  int64  `json:"checkedAt"`
+
+	// Highlights is only set on bookmarks returned by SearchBookmarks
+	Highlights *Highlights `json:"-"`
 }
 
 func BookmarkFromRequest(r *http.Request) (b *Bookmark) {
@@ -28,6 +50,7 @@ func BookmarkFromRequest(r *http.Request) (b *Bookmark) {
 		Description: r.FormValue("description"),
 		Shortcut:    r.FormValue("shortcut"),
 		ReadAt:      0,
+		IsPrivate:   r.FormValue("private") != "",
 	}
 
 	if r.FormValue("toread") == "" {
@@ -43,10 +66,58 @@ func BookmarkFromRequest(r *http.Request) (b *Bookmark) {
 	return
 }
 
+// MarshalJSON encodes tags and authors as arrays rather than the space
+// separated string they're stored as
+func (b Bookmark) MarshalJSON() ([]byte, error) {
+	type bookmark Bookmark
+	return json.Marshal(struct {
+		bookmark
+		Tags    []string `json:"tags"`
+		Authors []string `json:"authors"`
+		ToRead  bool     `json:"toRead"`
+	}{bookmark(b), b.ParseTags(), b.ParseAuthors(), b.ToRead()})
+}
+
 func (b *Bookmark) ToRead() bool {
 	return b.ReadAt == 0
 }
 
+// Host is the host the bookmark points at, which is also what its icon is
+// stored under
+func (b *Bookmark) Host() string {
+	return HostOf(b.URL)
+}
+
+// ReadingTime is how many minutes reading the page is estimated to take,
+// 0 if its length isn't known
+func (b *Bookmark) ReadingTime() int {
+	return readingTime(b.WordCount)
+}
+
+// IsBroken reports whether the link checker got an error response or
+// none at all the last time it checked the URL
+func (b *Bookmark) IsBroken() bool {
+	return b.CheckedAt != 0 && (b.LinkStatus >= 400 || b.LinkError != "")
+}
+
+// Moved reports whether the URL redirects somewhere else
+func (b *Bookmark) Moved() bool {
+	return b.LinkURL != "" && b.LinkURL != b.URL
+}
+
+// LinkProblem describes what's wrong with a broken link
+func (b *Bookmark) LinkProblem() string {
+	if b.LinkError != "" {
+		return b.LinkError
+	}
+	return fmt.Sprintf("%d %s", b.LinkStatus, http.StatusText(b.LinkStatus))
+}
+
+func (b *Bookmark) TimeChecked() *time.Time {
+	tm := time.Unix(b.CheckedAt, 0)
+	return &tm
+}
+
 func (b *Bookmark) TimeCreated() *time.Time {
 	tm := time.Unix(b.CreatedAt, 0)
 	return &tm
@@ -57,6 +128,11 @@ func (b *Bookmark) TimeUpdated() *time.Time {
 	return &tm
 }
 
+func (b *Bookmark) TimeDeleted() *time.Time {
+	tm := time.Unix(b.DeletedAt, 0)
+	return &tm
+}
+
 func (b *Bookmark) TimeRead() *time.Time {
 	tm := time.Unix(b.ReadAt, 0)
 	return &tm
@@ -99,6 +175,6 @@ func (b *Bookmark) ParseAuthors() (authors []string) {
 type Tag struct {
 	ID         int64  `json:"id"`
 	Name       string `json:"name"`
-	IsAuthor   bool   `json:"is_author"`
-	NumEntries int64  `json:"num_entries"`
+	IsAuthor   bool   `json:"isAuthor"`
+	NumEntries int64  `json:"numEntries"`
 }
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PAGE_SIZE = 100
+const MAX_PAGE_SIZE = 1000
+
+// ErrInvalidCursor is returned for a cursor from a different kind of
+// listing, like a position in ranked search results passed to a listing
+// sorted by date
+var ErrInvalidCursor = errors.New("cursor doesn't belong to this listing")
+
+// Cursor points at a single bookmark in a listing sorted newest first.
+// Bookmarks are ordered by (created_at, id) so the cursor stays stable even
+// when several bookmarks were created within the same second.
+//
+// Search results ranked by relevance have no such order, so their cursors
+// hold a position in the results instead, written as r<offset>.
+type Cursor struct {
+	CreatedAt int64
+	ID        int64
+	Offset    int
+}
+
+func CursorFor(b Bookmark) *Cursor {
+	return &Cursor{CreatedAt: b.CreatedAt, ID: b.ID}
+}
+
+func ParseCursor(s string) (c *Cursor, err error) {
+	c = &Cursor{}
+	invalid := fmt.Errorf("invalid cursor %q", s)
+
+	if strings.HasPrefix(s, "r") {
+		if c.Offset, err = strconv.Atoi(s[1:]); err != nil || c.Offset <= 0 {
+			return nil, invalid
+		}
+		return c, nil
+	}
+
+	createdAt, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, invalid
+	}
+
+	if c.CreatedAt, err = strconv.ParseInt(createdAt, 10, 64); err != nil {
+		return nil, invalid
+	}
+
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, invalid
+	}
+
+	return c, nil
+}
+
+func (c *Cursor) String() string {
+	if c.Offset > 0 {
+		return fmt.Sprintf("r%d", c.Offset)
+	}
+	return fmt.Sprintf("%d-%d", c.CreatedAt, c.ID)
+}
+
+func (c *Cursor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Page selects a slice of a listing. Before returns bookmarks older than the
+// cursor, After returns bookmarks newer than the cursor. If neither is set
+// the listing starts with the newest bookmark.
+type Page struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+}
+
+func (p Page) limit() int {
+	if p.Limit <= 0 {
+		return DEFAULT_PAGE_SIZE
+	}
+	if p.Limit > MAX_PAGE_SIZE {
+		return MAX_PAGE_SIZE
+	}
+	return p.Limit
+}
+
+// Pagination describes where to go from the current page. Next should be
+// passed as Page.Before and Prev as Page.After. Either is nil when there is
+// nothing more in that direction.
+type Pagination struct {
+	Next *Cursor `json:"next,omitempty"`
+	Prev *Cursor `json:"prev,omitempty"`
+}
+
+// fetchPage runs a select over bookmarks (aliased as "b") restricted by the
+// where conditions and sliced according to the page.
+func fetchPage(where []string, p Page, args ...any) (bookmarks []Bookmark, pg Pagination, err error) {
+	if (p.Before != nil && p.Before.Offset > 0) || (p.After != nil && p.After.Offset > 0) {
+		return nil, pg, ErrInvalidCursor
+	}
+
+	order := "desc"
+	if p.Before != nil {
+		where = append(where, "(b.created_at < ? or (b.created_at = ? and b.id < ?))")
+		args = append(args, p.Before.CreatedAt, p.Before.CreatedAt, p.Before.ID)
+	} else if p.After != nil {
+		where = append(where, "(b.created_at > ? or (b.created_at = ? and b.id > ?))")
+		args = append(args, p.After.CreatedAt, p.After.CreatedAt, p.After.ID)
+		order = "asc"
+	}
+
+	limit := p.limit()
+	q := fmt.Sprintf(`
+	select %s
+	from bookmarks b
+	where %s
+	order by b.created_at %s, b.id %s
+	limit %d;
+	`, BOOKMARK_COLUMNS, strings.Join(where, " and "), order, order, limit+1)
+
+	bookmarks, err = fetchBookmarks(q, args...)
+	if err != nil {
+		return nil, pg, err
+	}
+
+	more := len(bookmarks) > limit
+	if more {
+		bookmarks = bookmarks[:limit]
+	}
+
+	if p.After != nil {
+		for i, j := 0, len(bookmarks)-1; i < j; i, j = i+1, j-1 {
+			bookmarks[i], bookmarks[j] = bookmarks[j], bookmarks[i]
+		}
+	}
+
+	if len(bookmarks) == 0 {
+		return
+	}
+
+	first := CursorFor(bookmarks[0])
+	last := CursorFor(bookmarks[len(bookmarks)-1])
+
+	if p.After != nil {
+		pg.Next = last
+		if more {
+			pg.Prev = first
+		}
+	} else {
+		if more {
+			pg.Next = last
+		}
+		if p.Before != nil {
+			pg.Prev = first
+		}
+	}
+
+	return
+}
@@ -0,0 +1,41 @@
+package data
+
+import "testing"
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *Cursor
+	}{
+		{"1667000000-42", &Cursor{CreatedAt: 1667000000, ID: 42}},
+		{"0-0", &Cursor{}},
+		{"r100", &Cursor{Offset: 100}},
+		{"1-2xyz", nil},
+		{"1-", nil},
+		{"-2", nil},
+		{"1 -2", nil},
+		{"12", nil},
+		{"r0", nil},
+		{"r-5", nil},
+		{"r", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCursor(tt.s)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("ParseCursor(%q) = %+v, want an error", tt.s, got)
+			}
+			continue
+		}
+
+		if err != nil || *got != *tt.want {
+			t.Errorf("ParseCursor(%q) = %+v, %v, want %+v", tt.s, got, err, tt.want)
+		}
+
+		if got.String() != tt.s {
+			t.Errorf("ParseCursor(%q).String() = %q", tt.s, got.String())
+		}
+	}
+}
@@ -3,8 +3,52 @@ package data
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 )
 
+const BOOKMARK_COLUMNS = `
+	b.id,
+	b.url,
+	b.title,
+	b.shortcut,
+	b.description,
+	b.tags,
+	b.created_at,
+	b.updated_at,
+	b.deleted_at,
+	b.read_at,
+	b.is_private,
+	coalesce((select a.archived_at from archives a where a.bookmark_id = b.id), 0),
+	coalesce((select ar.word_count from articles ar where ar.bookmark_id = b.id), 0),
+	b.link_status,
+	b.link_url,
+	b.link_error,
+	b.checked_at`
+
+// bookmarkFields returns pointers to the fields of b in the same order
+// as BOOKMARK_COLUMNS so they can be passed to Scan
+func bookmarkFields(b *Bookmark) []any {
+	return []any{
+		&b.ID,
+		&b.URL,
+		&b.Title,
+		&b.Shortcut,
+		&b.Description,
+		&b.Tags,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+		&b.DeletedAt,
+		&b.ReadAt,
+		&b.IsPrivate,
+		&b.ArchivedAt,
+		&b.WordCount,
+		&b.LinkStatus,
+		&b.LinkURL,
+		&b.LinkError,
+		&b.CheckedAt,
+	}
+}
+
 func fetchBookmarks(q string, args ...any) (bookmarks []Bookmark, err error) {
 	rows, err := db.Query(q, args...)
 	if err != nil {
@@ -14,18 +58,7 @@ func fetchBookmarks(q string, args ...any) (bookmarks []Bookmark, err error) {
 
 	for rows.Next() {
 		var b Bookmark
-		err = rows.Scan(
-			&b.ID,
-			&b.URL,
-			&b.Title,
-			&b.Shortcut,
-			&b.Description,
-			&b.Tags,
-			&b.CreatedAt,
-			&b.UpdatedAt,
-			&b.DeletedAt,
-			&b.ReadAt)
-
+		err = rows.Scan(bookmarkFields(&b)...)
 		if err != nil {
 			return nil, err
 		}
@@ -40,10 +73,17 @@ func fetchBookmarks(q string, args ...any) (bookmarks []Bookmark, err error) {
 	return
 }
 
-func fetchTags(where string, args ...any) (tags []Tag, err error) {
+// fetchTags returns the tags used by bookmarks in the scope. Every user
+// has their own tags so when the scope spans several users tags with the
+// same name are counted together.
+func fetchTags(s Scope, where string, args ...any) (tags []Tag, err error) {
+	for _, w := range s.where() {
+		where = where + " and " + w
+	}
+
 	q := fmt.Sprintf(`
 	select
-		t.id,
+		min(t.id),
 		t.name,
 		t.is_author,
 		count(tb.tag_id) as num_entries
@@ -51,7 +91,7 @@ func fetchTags(where string, args ...any) (tags []Tag, err error) {
 	join tags_bookmarks tb on tb.tag_id = t.id
 	join bookmarks b on tb.bookmark_id = b.id
 	where %s and b.deleted_at = 0
-	group by t.id, t.name, t.is_author;
+	group by t.name, t.is_author;
 	`, where)
 
 	rows, err := db.Query(q, args...)
@@ -76,100 +116,49 @@ func fetchTags(where string, args ...any) (tags []Tag, err error) {
 	return
 }
 
-func FetchAllBookmarks() (bookmarks []Bookmark, err error) {
-	q := `
-	select
-		id,
-		url,
-		title,
-		shortcut,
-		description,
-		tags,
-		created_at,
-		updated_at,
-		deleted_at,
-		read_at
-	from bookmarks
-	where deleted_at = 0
-	order by created_at desc;
-	`
-
-	return fetchBookmarks(q)
+func FetchAllBookmarks(s Scope, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	where := []string{"b.deleted_at = 0"}
+	where = append(where, s.where()...)
+	return fetchPage(where, p)
 }
 
-func FetchUnreadBookmarks() (bookmarks []Bookmark, err error) {
-	q := `
-	select
-		id,
-		url,
-		title,
-		shortcut,
-		description,
-		tags,
-		created_at,
-		updated_at,
-		deleted_at,
-		read_at
-	from bookmarks
-	where read_at = 0 and deleted_at = 0
-	order by created_at desc;
-	`
-
-	return fetchBookmarks(q)
+func FetchUnreadBookmarks(s Scope, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	where := []string{"b.read_at = 0", "b.deleted_at = 0"}
+	where = append(where, s.where()...)
+	return fetchPage(where, p)
 }
 
-func FetchShortcuts() (bookmarks []Bookmark, err error) {
-	q := `
-	select
-		id,
-		url,
-		title,
-		shortcut,
-		description,
-		tags,
-		created_at,
-		updated_at,
-		deleted_at,
-		read_at
-	from bookmarks
-	where shortcut <> "" and deleted_at = 0
-	order by created_at desc;
-	`
-
-	return fetchBookmarks(q)
+func FetchShortcuts(s Scope, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	where := []string{`b.shortcut <> ""`, "b.deleted_at = 0"}
+	where = append(where, s.where()...)
+	return fetchPage(where, p)
 }
 
-func FetchBookmarkByID(id int64) (bookmark *Bookmark, err error) {
-	q := `
-	select
-		id,
-		url,
-		title,
-		shortcut,
-		description,
-		tags,
-		created_at,
-		updated_at,
-		deleted_at,
-		read_at
-	from bookmarks
-	where id = ? and deleted_at = 0
+func FetchDeletedBookmarks(s Scope, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	where := []string{"b.deleted_at <> 0"}
+	where = append(where, s.where()...)
+	return fetchPage(where, p)
+}
+
+// FetchBrokenBookmarks returns the bookmarks whose links the link checker
+// found to be broken
+func FetchBrokenBookmarks(s Scope, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	where := []string{"b.deleted_at = 0", "b.checked_at <> 0", "(b.link_status >= 400 or b.link_error <> '')"}
+	where = append(where, s.where()...)
+	return fetchPage(where, p)
+}
+
+func FetchBookmarkByID(s Scope, id int64) (bookmark *Bookmark, err error) {
+	where := append([]string{"b.id = ?", "b.deleted_at = 0"}, s.where()...)
+	q := fmt.Sprintf(`
+	select %s
+	from bookmarks b
+	where %s
 	limit 1
-	`
+	`, BOOKMARK_COLUMNS, strings.Join(where, " and "))
 	b := Bookmark{}
 	row := db.QueryRow(q, id)
-	err = row.Scan(
-		&b.ID,
-		&b.URL,
-		&b.Title,
-		&b.Shortcut,
-		&b.Description,
-		&b.Tags,
-		&b.CreatedAt,
-		&b.UpdatedAt,
-		&b.DeletedAt,
-		&b.ReadAt,
-	)
+	err = row.Scan(bookmarkFields(&b)...)
 
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -181,38 +170,26 @@ func FetchBookmarkByID(id int64) (bookmark *Bookmark, err error) {
 	return &b, nil
 }
 
-func FetchBookmarksByTag(name string) (bookmarks []Bookmark, err error) {
-	q := `
-	select
-		b.id,
-		b.url,
-		b.title,
-		b.shortcut,
-		b.description,
-		b.tags,
-		b.created_at,
-		b.updated_at,
-		b.deleted_at,
-		b.read_at
-	from bookmarks b
-	join tags_bookmarks tb on tb.bookmark_id = b.id
-	join tags t on t.id = tb.tag_id
-	where t.name = ? and b.deleted_at = 0
-	order by b.created_at desc;
-	`
-
-	return fetchBookmarks(q, name)
+func FetchBookmarksByTag(s Scope, name string, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	where := []string{
+		`exists (
+			select 1 from tags_bookmarks tb
+			join tags t on t.id = tb.tag_id
+			where tb.bookmark_id = b.id and t.name = ?)`,
+		"b.deleted_at = 0",
+	}
+	where = append(where, s.where()...)
+	return fetchPage(where, p, name)
 }
 
-func GetShortcutURL(name string) (string, bool) {
-	q := `
-	select url
-	from bookmarks
-	where
-		shortcut = ? and
-		deleted_at = 0
-	order by created_at desc
-	limit 1`
+func GetShortcutURL(s Scope, name string) (string, bool) {
+	where := append([]string{"b.shortcut = ?", "b.deleted_at = 0"}, s.where()...)
+	q := fmt.Sprintf(`
+	select b.url
+	from bookmarks b
+	where %s
+	order by b.created_at desc
+	limit 1`, strings.Join(where, " and "))
 
 	var url string
 	if err := db.QueryRow(q, name).Scan(&url); err != nil {
@@ -225,12 +202,24 @@ func GetShortcutURL(name string) (string, bool) {
 	return url, true
 }
 
-func FetchAllTags() (tags []Tag, err error) {
+func FetchAllTags(s Scope) (tags []Tag, err error) {
 	w := `t.is_author = 0`
-	return fetchTags(w)
+	return fetchTags(s, w)
 }
 
-func FetchAllAuthors() (tags []Tag, err error) {
+func FetchAllAuthors(s Scope) (tags []Tag, err error) {
 	w := `t.is_author = 1`
-	return fetchTags(w)
+	return fetchTags(s, w)
+}
+
+// LastUpdated returns the most recent time any bookmark in the scope was
+// added, changed or deleted
+func LastUpdated(s Scope) (ts int64, err error) {
+	where := append([]string{"1 = 1"}, s.where()...)
+	q := fmt.Sprintf(`
+	select coalesce(max(max(b.updated_at), max(b.deleted_at)), 0)
+	from bookmarks b
+	where %s`, strings.Join(where, " and "))
+	err = db.QueryRow(q).Scan(&ts)
+	return
 }
@@ -0,0 +1,308 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed search string such as:
+//
+//	tag:go -tag:old by:rob is:unread after:2022-01-01 site:github.com "exact phrase"
+//
+// All terms must match for a bookmark to be included in the results.
+// Any term can be negated by prefixing it with a dash.
+type Query struct {
+	Terms []Term
+}
+
+// Term is a single node of a parsed Query. It knows how to compile itself
+// into a SQL condition against the bookmarks table aliased as "b".
+type Term interface {
+	compile(c *compiler)
+	String() string
+}
+
+// TextTerm matches a word or an "exact phrase" anywhere in the url, title,
+// description, tags or article text of a bookmark using the full-text
+// index.
+type TextTerm struct {
+	Text    string
+	Phrase  bool
+	Negated bool
+}
+
+// TagTerm matches bookmarks tagged with Name. Authors are tags too, so
+// by:rob is a TagTerm with Name set to "by:rob".
+type TagTerm struct {
+	Name    string
+	Negated bool
+}
+
+// StateTerm matches bookmarks by state: "unread", "read" or "shortcut".
+type StateTerm struct {
+	State   string
+	Negated bool
+}
+
+// DateTerm matches bookmarks created on or after (after:) or strictly
+// before (before:) the given day.
+type DateTerm struct {
+	Date    time.Time
+	Before  bool
+	Negated bool
+}
+
+// SiteTerm matches bookmarks whose URL host is Host or any of its
+// subdomains.
+type SiteTerm struct {
+	Host    string
+	Negated bool
+}
+
+const dateFormat = "2006-01-02"
+
+type QueryError struct {
+	Token  string
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid search term %q: %s", e.Token, e.Reason)
+}
+
+// ParseQuery parses a search string into a Query. Unknown operators
+// (anything before a colon that isn't tag, by, is, after, before or site)
+// are searched for as plain text so that pasting a URL just works.
+func ParseQuery(s string) (q *Query, err error) {
+	q = &Query{Terms: []Term{}}
+
+	for _, raw := range splitTerms(s) {
+		t, err := parseTerm(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if t != nil {
+			q.Terms = append(q.Terms, t)
+		}
+	}
+
+	return q, nil
+}
+
+func (q *Query) IsEmpty() bool {
+	return q == nil || len(q.Terms) == 0
+}
+
+func (q *Query) String() string {
+	terms := []string{}
+	for _, t := range q.Terms {
+		terms = append(terms, t.String())
+	}
+	return strings.Join(terms, " ")
+}
+
+// splitTerms splits s on whitespace, keeping double-quoted runs together
+// so that both "exact phrase" and tag:"some tag" end up as a single term.
+func splitTerms(s string) (terms []string) {
+	var cur strings.Builder
+	inQuotes := false
+
+	for _, r := range s {
+		if r == '"' {
+			inQuotes = !inQuotes
+		}
+
+		if !inQuotes && unicode.IsSpace(r) {
+			if cur.Len() > 0 {
+				terms = append(terms, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+
+		cur.WriteRune(r)
+	}
+
+	if cur.Len() > 0 {
+		terms = append(terms, cur.String())
+	}
+
+	return
+}
+
+func parseTerm(raw string) (Term, error) {
+	s := raw
+	negated := false
+	if len(s) > 1 && strings.HasPrefix(s, "-") {
+		negated = true
+		s = s[1:]
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		text := strings.Trim(s, `"`)
+		if text == "" {
+			return nil, nil
+		}
+		return &TextTerm{Text: text, Phrase: true, Negated: negated}, nil
+	}
+
+	key, value, found := strings.Cut(s, ":")
+	value = strings.Trim(value, `"`)
+	if found && value != "" {
+		switch strings.ToLower(key) {
+		case "tag":
+			return &TagTerm{Name: value, Negated: negated}, nil
+		case "by":
+			return &TagTerm{Name: "by:" + value, Negated: negated}, nil
+		case "is":
+			state := strings.ToLower(value)
+			switch state {
+			case "unread", "read", "shortcut":
+				return &StateTerm{State: state, Negated: negated}, nil
+			}
+			return nil, &QueryError{raw, "expected is:unread, is:read or is:shortcut"}
+		case "after", "before":
+			d, err := time.ParseInLocation(dateFormat, value, time.Local)
+			if err != nil {
+				return nil, &QueryError{raw, "dates must look like 2022-01-31"}
+			}
+			return &DateTerm{Date: d, Before: strings.ToLower(key) == "before", Negated: negated}, nil
+		case "site":
+			host := strings.ToLower(strings.Trim(value, "/"))
+			return &SiteTerm{Host: host, Negated: negated}, nil
+		}
+	}
+
+	// Not an operator we know about, search for the whole thing as text
+	text := strings.ReplaceAll(s, `"`, "")
+	if text == "" {
+		return nil, nil
+	}
+	return &TextTerm{Text: text, Negated: negated}, nil
+}
+
+func negate(negated bool, s string) string {
+	if negated {
+		return "-" + s
+	}
+	return s
+}
+
+func (t *TextTerm) String() string {
+	if t.Phrase {
+		return negate(t.Negated, `"`+t.Text+`"`)
+	}
+	return negate(t.Negated, t.Text)
+}
+
+func (t *TagTerm) String() string {
+	if strings.HasPrefix(t.Name, "by:") {
+		return negate(t.Negated, t.Name)
+	}
+	return negate(t.Negated, "tag:"+t.Name)
+}
+
+func (t *StateTerm) String() string {
+	return negate(t.Negated, "is:"+t.State)
+}
+
+func (t *DateTerm) String() string {
+	if t.Before {
+		return negate(t.Negated, "before:"+t.Date.Format(dateFormat))
+	}
+	return negate(t.Negated, "after:"+t.Date.Format(dateFormat))
+}
+
+func (t *SiteTerm) String() string {
+	return negate(t.Negated, "site:"+t.Host)
+}
+
+// compiler accumulates SQL conditions and their arguments. Positive text
+// terms are collected separately into a single FTS5 match expression so
+// results can be ranked; everything else becomes a where condition.
+type compiler struct {
+	where []string
+	args  []any
+	match []string
+}
+
+func (c *compiler) add(negated bool, cond string, args ...any) {
+	if negated {
+		cond = "not " + cond
+	}
+	c.where = append(c.where, cond)
+	c.args = append(c.args, args...)
+}
+
+func (q *Query) compile() *compiler {
+	c := &compiler{}
+	for _, t := range q.Terms {
+		t.compile(c)
+	}
+	return c
+}
+
+// fts quotes the text so characters that are special to FTS5 (such as the
+// colon in "by:anton" or a stray quote) are matched literally.
+func (t *TextTerm) fts() string {
+	return `"` + strings.ReplaceAll(t.Text, `"`, `""`) + `"`
+}
+
+func (t *TextTerm) compile(c *compiler) {
+	if !t.Negated {
+		c.match = append(c.match, t.fts())
+		return
+	}
+
+	c.add(true, `(b.id in (select rowid from bookmarks_fts where bookmarks_fts match ?))`, t.fts())
+}
+
+func (t *TagTerm) compile(c *compiler) {
+	c.add(t.Negated, `exists (
+		select 1 from tags_bookmarks tb
+		join tags t on t.id = tb.tag_id
+		where tb.bookmark_id = b.id and t.name = ?)`, t.Name)
+}
+
+func (t *StateTerm) compile(c *compiler) {
+	switch t.State {
+	case "unread":
+		c.add(t.Negated, `(b.read_at = 0)`)
+	case "read":
+		c.add(t.Negated, `(b.read_at <> 0)`)
+	case "shortcut":
+		c.add(t.Negated, `(b.shortcut <> "")`)
+	}
+}
+
+func (t *DateTerm) compile(c *compiler) {
+	if t.Before {
+		c.add(t.Negated, `(b.created_at < ?)`, t.Date.Unix())
+		return
+	}
+	c.add(t.Negated, `(b.created_at >= ?)`, t.Date.Unix())
+}
+
+// URL_HOST is an SQL expression for the host of b.url without the scheme,
+// port and everything after them, e.g. go.dev for https://go.dev:443/blog
+var URL_HOST = func() string {
+	expr := `substr(b.url, instr(b.url, '://') + 3)`
+	for _, sep := range []string{"/", "?", "#", ":"} {
+		expr = fmt.Sprintf(`substr(%[1]s, 1, instr(%[1]s || '%[2]s', '%[2]s') - 1)`, expr, sep)
+	}
+	return "lower(" + expr + ")"
+}()
+
+// escapeLike makes s match itself in a LIKE pattern with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (t *SiteTerm) compile(c *compiler) {
+	c.add(t.Negated, fmt.Sprintf(`(%[1]s = ? or %[1]s like ? escape '\')`, URL_HOST),
+		t.Host,
+		"%."+escapeLike(t.Host))
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	day := time.Date(2022, 1, 31, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		s     string
+		terms []Term
+		str   string
+	}{
+		{"go", []Term{&TextTerm{Text: "go"}}, "go"},
+		{"  go   channels ", []Term{&TextTerm{Text: "go"}, &TextTerm{Text: "channels"}}, "go channels"},
+		{`"exact  phrase"`, []Term{&TextTerm{Text: "exact  phrase", Phrase: true}}, `"exact  phrase"`},
+		{`-"exact phrase"`, []Term{&TextTerm{Text: "exact phrase", Phrase: true, Negated: true}}, `-"exact phrase"`},
+		{`say"hi`, []Term{&TextTerm{Text: "sayhi"}}, "sayhi"},
+		{"-old", []Term{&TextTerm{Text: "old", Negated: true}}, "-old"},
+		{"-", []Term{&TextTerm{Text: "-"}}, "-"},
+		{"tag:go -tag:old", []Term{&TagTerm{Name: "go"}, &TagTerm{Name: "old", Negated: true}}, "tag:go -tag:old"},
+		{`tag:"some tag"`, []Term{&TagTerm{Name: "some tag"}}, "tag:some tag"},
+		{"TAG:Go", []Term{&TagTerm{Name: "Go"}}, "tag:Go"},
+		{"by:rob -by:ken", []Term{&TagTerm{Name: "by:rob"}, &TagTerm{Name: "by:ken", Negated: true}}, "by:rob -by:ken"},
+		{"is:Unread -is:shortcut", []Term{&StateTerm{State: "unread"}, &StateTerm{State: "shortcut", Negated: true}}, "is:unread -is:shortcut"},
+		{"after:2022-01-31", []Term{&DateTerm{Date: day}}, "after:2022-01-31"},
+		{"-before:2022-01-31", []Term{&DateTerm{Date: day, Before: true, Negated: true}}, "-before:2022-01-31"},
+		{"site:GitHub.com/", []Term{&SiteTerm{Host: "github.com"}}, "site:github.com"},
+		{"-site:a_b.example", []Term{&SiteTerm{Host: "a_b.example", Negated: true}}, "-site:a_b.example"},
+
+		// Unknown operators and operators without a value are plain text
+		{"https://go.dev/blog", []Term{&TextTerm{Text: "https://go.dev/blog"}}, "https://go.dev/blog"},
+		{"tag:", []Term{&TextTerm{Text: "tag:"}}, "tag:"},
+		{`tag:""`, []Term{&TextTerm{Text: "tag:"}}, "tag:"},
+
+		{"", []Term{}, ""},
+		{`"" -""`, []Term{}, ""},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.s)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(q.Terms, tt.terms) {
+			t.Errorf("ParseQuery(%q) = %s, want %s", tt.s, termTypes(q.Terms), termTypes(tt.terms))
+		}
+
+		if q.String() != tt.str {
+			t.Errorf("ParseQuery(%q).String() = %q, want %q", tt.s, q.String(), tt.str)
+		}
+	}
+}
+
+func termTypes(terms []Term) string {
+	s := []string{}
+	for _, t := range terms {
+		s = append(s, reflect.TypeOf(t).Elem().Name()+"("+t.String()+")")
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, s := range []string{
+		"is:starred",
+		"after:yesterday",
+		"before:2022-13-01",
+		"after:2022-1-31",
+	} {
+		q, err := ParseQuery(s)
+		if _, ok := err.(*QueryError); !ok {
+			t.Errorf("ParseQuery(%q) = %v, %v, want a QueryError", s, q, err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	q, err := ParseQuery(`go "exact phrase" -old tag:go -is:read`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := q.compile()
+	if want := []string{`"go"`, `"exact phrase"`}; !reflect.DeepEqual(c.match, want) {
+		t.Errorf("match = %q, want %q", c.match, want)
+	}
+
+	if len(c.where) != 3 || !strings.HasPrefix(c.where[0], "not ") || !strings.HasPrefix(c.where[2], "not ") {
+		t.Errorf("where = %q, want negated text, tag and negated state conditions", c.where)
+	}
+
+	if want := []any{`"old"`, "go"}; !reflect.DeepEqual(c.args, want) {
+		t.Errorf("args = %q, want %q", c.args, want)
+	}
+
+	if got := (&TextTerm{Text: `by:"anton"`}).fts(); got != `"by:""anton"""` {
+		t.Errorf("fts() = %s", got)
+	}
+
+	if got := escapeLike(`a_b%c\d`); got != `a\_b\%c\\d` {
+		t.Errorf("escapeLike = %s", got)
+	}
+}
+
+func TestSearchBookmarks(t *testing.T) {
+	openTestDB(t)
+
+	jan := time.Date(2022, 1, 15, 12, 0, 0, 0, time.Local).Unix()
+	feb := time.Date(2022, 2, 15, 12, 0, 0, 0, time.Local).Unix()
+	addTestBookmarks(t,
+		Bookmark{URL: "https://go.dev/blog/pipelines", Title: "Go Concurrency Patterns: Pipelines", Tags: "go concurrency", CreatedAt: jan},
+		Bookmark{URL: "https://blog.go.dev:8443/gc", Title: "Getting to Go", Tags: "go gc", CreatedAt: feb, ReadAt: feb},
+		Bookmark{URL: "https://golang.org/?ref=go.dev", Title: "The Go Programming Language", Tags: "go", CreatedAt: feb},
+		Bookmark{URL: "https://a_b.example/", Title: "Underscore", CreatedAt: jan},
+		Bookmark{URL: "https://axb.example/", Title: "Not an underscore", CreatedAt: jan},
+		Bookmark{URL: "HTTPS://Sub.A_B.Example/path", Title: "Subdomain", CreatedAt: jan},
+	)
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"site:go.dev", []string{"Getting to Go", "Go Concurrency Patterns: Pipelines"}},
+		{"-site:go.dev tag:go", []string{"The Go Programming Language"}},
+		{"site:a_b.example", []string{"Subdomain", "Underscore"}},
+		{"site:%.example", nil},
+		{"tag:go -tag:gc", []string{"Go Concurrency Patterns: Pipelines", "The Go Programming Language"}},
+		{"tag:go is:unread before:2022-02-01", []string{"Go Concurrency Patterns: Pipelines"}},
+		{"tag:go after:2022-02-15", []string{"Getting to Go", "The Go Programming Language"}},
+		{"-is:unread", []string{"Getting to Go"}},
+		{"pipelines", []string{"Go Concurrency Patterns: Pipelines"}},
+		{`"programming language" site:golang.org`, []string{"The Go Programming Language"}},
+		{"go -concurrency -underscore site:go.dev", []string{"Getting to Go"}},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.q)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", tt.q, err)
+		}
+
+		bookmarks, _, err := SearchBookmarks(Scope{}, q, Page{})
+		if err != nil {
+			t.Fatalf("SearchBookmarks(%q): %v", tt.q, err)
+		}
+
+		var got []string
+		for _, b := range bookmarks {
+			got = append(got, b.Title)
+		}
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchBookmarks(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
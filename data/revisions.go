@@ -0,0 +1,119 @@
+package data
+
+import (
+	"time"
+)
+
+// Revision is a snapshot of the user-editable fields of a bookmark. A new
+// revision is recorded every time a bookmark is added or one of these
+// fields changes.
+type Revision struct {
+	ID          int64  `json:"id"`
+	BookmarkID  int64  `json:"bookmarkId"`
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Shortcut    string `json:"shortcut"`
+	Description string `json:"description"`
+	Tags        string `json:"tags"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func revisionOf(b Bookmark) Revision {
+	return Revision{
+		BookmarkID:  b.ID,
+		URL:         b.URL,
+		Title:       b.Title,
+		Shortcut:    b.Shortcut,
+		Description: b.Description,
+		Tags:        b.Tags,
+		CreatedAt:   b.UpdatedAt,
+	}
+}
+
+func (r *Revision) TimeCreated() *time.Time {
+	tm := time.Unix(r.CreatedAt, 0)
+	return &tm
+}
+
+// Diff lists the fields that changed between prev and r. If prev is nil
+// every non-empty field of r is reported as new.
+func (r *Revision) Diff(prev *Revision) (changes []FieldChange) {
+	if prev == nil {
+		prev = &Revision{}
+	}
+
+	fields := []FieldChange{
+		{"url", prev.URL, r.URL},
+		{"title", prev.Title, r.Title},
+		{"shortcut", prev.Shortcut, r.Shortcut},
+		{"description", prev.Description, r.Description},
+		{"tags", prev.Tags, r.Tags},
+	}
+
+	for _, f := range fields {
+		if f.Old != f.New {
+			changes = append(changes, f)
+		}
+	}
+
+	return
+}
+
+func (r *Revision) sameAs(other Revision) bool {
+	return len(r.Diff(&other)) == 0
+}
+
+// FetchRevisions returns the history of a bookmark, newest first
+func FetchRevisions(bookmarkID int64) (revisions []Revision, err error) {
+	q := `
+	select
+		id,
+		bookmark_id,
+		url,
+		title,
+		shortcut,
+		description,
+		tags,
+		created_at
+	from bookmark_revisions
+	where bookmark_id = ?
+	order by created_at desc, id desc;
+	`
+
+	rows, err := db.Query(q, bookmarkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Revision
+		err = rows.Scan(
+			&r.ID,
+			&r.BookmarkID,
+			&r.URL,
+			&r.Title,
+			&r.Shortcut,
+			&r.Description,
+			&r.Tags,
+			&r.CreatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return
+}
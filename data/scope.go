@@ -0,0 +1,31 @@
+package data
+
+import "fmt"
+
+// Scope limits which bookmarks a query can see. A Scope with a UserID only
+// sees that user's library, otherwise bookmarks of all users are included.
+// Private bookmarks (and the tags used only by them) are hidden unless
+// Private is set, which should only be the case for the owner.
+type Scope struct {
+	UserID  int64
+	Private bool
+}
+
+// OwnScope is the scope of a user looking at their own library
+func OwnScope(userID int64) Scope {
+	return Scope{UserID: userID, Private: true}
+}
+
+// where returns the conditions, against the bookmarks table aliased as
+// "b", that hide everything outside of the scope
+func (s Scope) where() (where []string) {
+	if s.UserID != 0 {
+		where = append(where, fmt.Sprintf("b.user_id = %d", s.UserID))
+	}
+
+	if !s.Private {
+		where = append(where, "b.is_private = 0")
+	}
+
+	return where
+}
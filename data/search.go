@@ -0,0 +1,122 @@
+package data
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Markers passed to the FTS5 highlight() and snippet() functions. They are
+// control characters so they can't clash with anything a user would type
+// and are replaced with <mark> tags after the text is escaped.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+type Highlights struct {
+	Title   template.HTML
+	Snippet template.HTML
+}
+
+func highlightHTML(s string) template.HTML {
+	safe := template.HTMLEscapeString(s)
+	safe = strings.ReplaceAll(safe, markStart, "<mark>")
+	safe = strings.ReplaceAll(safe, markEnd, "</mark>")
+	return template.HTML(safe)
+}
+
+// SearchBookmarks returns bookmarks matching all terms of the query. When
+// the query contains free text the results are ranked by relevance, come
+// with highlights and are paginated by their position in the ranking.
+// Otherwise they are sorted newest first and paginated like any other
+// listing.
+func SearchBookmarks(s Scope, q *Query, p Page) (bookmarks []Bookmark, pg Pagination, err error) {
+	if q.IsEmpty() {
+		return nil, pg, nil
+	}
+
+	c := q.compile()
+	where := append([]string{"b.deleted_at = 0"}, s.where()...)
+	where = append(where, c.where...)
+
+	if len(c.match) == 0 {
+		return fetchPage(where, p, c.args...)
+	}
+
+	if (p.Before != nil && p.Before.Offset == 0) || (p.After != nil && p.After.Offset == 0) {
+		return nil, pg, ErrInvalidCursor
+	}
+
+	// Going forward a page starts at the next cursor, going back it ends
+	// right before the prev cursor
+	limit := p.limit()
+	start := 0
+	if p.Before != nil {
+		start = p.Before.Offset
+	} else if p.After != nil {
+		if p.After.Offset > limit {
+			start = p.After.Offset - limit
+		}
+		limit = p.After.Offset - start
+	}
+
+	sq := fmt.Sprintf(`
+	select %s,
+		highlight(bookmarks_fts, 1, ?, ?),
+		snippet(bookmarks_fts, 2, ?, ?, '…', 32),
+		snippet(bookmarks_fts, 4, ?, ?, '…', 32)
+	from bookmarks_fts
+	join bookmarks b on b.id = bookmarks_fts.rowid
+	where bookmarks_fts match ? and %s
+	order by bookmarks_fts.rank
+	limit %d offset %d;
+	`, BOOKMARK_COLUMNS, strings.Join(where, " and "), limit+1, start)
+
+	args := []any{markStart, markEnd, markStart, markEnd, markStart, markEnd, strings.Join(c.match, " ")}
+	args = append(args, c.args...)
+
+	rows, err := db.Query(sq, args...)
+	if err != nil {
+		return nil, pg, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Bookmark
+		var title, snippet, textSnippet string
+		err = rows.Scan(append(bookmarkFields(&b), &title, &snippet, &textSnippet)...)
+
+		if err != nil {
+			return nil, pg, err
+		}
+
+		// Matches in the article text are shown when the description
+		// doesn't explain why the bookmark was found
+		if !strings.Contains(snippet, markStart) && strings.Contains(textSnippet, markStart) {
+			snippet = textSnippet
+		}
+
+		b.Highlights = &Highlights{
+			Title:   highlightHTML(title),
+			Snippet: highlightHTML(snippet),
+		}
+
+		bookmarks = append(bookmarks, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, pg, err
+	}
+
+	if len(bookmarks) > limit {
+		bookmarks = bookmarks[:limit]
+		pg.Next = &Cursor{Offset: start + limit}
+	}
+
+	if start > 0 {
+		pg.Prev = &Cursor{Offset: start}
+	}
+
+	return
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func ValidTagName(name string) bool {
+	return name != "" && name != "by:" && !strings.ContainsAny(name, " \t\r\n")
+}
+
+func (tx *Tx) tagID(name string) (id int64, err error) {
+	err = tx.sqlTx.QueryRow(`select id from tags where name = ? and user_id = ?`, name, tx.userID).Scan(&id)
+	return
+}
+
+// RenameTag renames a tag on every bookmark that uses it. Renaming a tag
+// to the name of another existing tag merges the two.
+func (tx *Tx) RenameTag(from, to string) (err error) {
+	if !ValidTagName(to) {
+		return fmt.Errorf("invalid tag name %q", to)
+	}
+
+	if from == to {
+		return nil
+	}
+
+	id, err := tx.tagID(from)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.tagID(to); err == nil {
+		return tx.MergeTags(from, to)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	q := `update tags set name = ?, is_author = ? where id = ?`
+	if _, err = tx.sqlTx.Exec(q, to, strings.HasPrefix(to, "by:"), id); err != nil {
+		return err
+	}
+
+	return tx.rewriteTags(id, func(t string) []string {
+		if t == from {
+			return []string{to}
+		}
+		return []string{t}
+	})
+}
+
+// MergeTags moves every bookmark tagged with from over to into and then
+// removes from
+func (tx *Tx) MergeTags(from, into string) (err error) {
+	if !ValidTagName(into) {
+		return fmt.Errorf("invalid tag name %q", into)
+	}
+
+	if from == into {
+		return nil
+	}
+
+	fromID, err := tx.tagID(from)
+	if err != nil {
+		return err
+	}
+
+	intoID, err := tx.AddTag(into)
+	if err != nil {
+		return err
+	}
+
+	err = tx.rewriteTags(fromID, func(t string) []string {
+		if t == from {
+			return []string{into}
+		}
+		return []string{t}
+	})
+	if err != nil {
+		return err
+	}
+
+	q1 := `
+	insert or ignore into tags_bookmarks (bookmark_id, tag_id)
+	select bookmark_id, ? from tags_bookmarks where tag_id = ?
+	`
+	if _, err = tx.sqlTx.Exec(q1, intoID, fromID); err != nil {
+		return err
+	}
+
+	return tx.deleteTagRow(fromID)
+}
+
+// DeleteTag removes a tag from every bookmark that uses it. The bookmarks
+// themselves are kept.
+func (tx *Tx) DeleteTag(name string) (err error) {
+	id, err := tx.tagID(name)
+	if err != nil {
+		return err
+	}
+
+	err = tx.rewriteTags(id, func(t string) []string {
+		if t == name {
+			return nil
+		}
+		return []string{t}
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.deleteTagRow(id)
+}
+
+func (tx *Tx) deleteTagRow(id int64) (err error) {
+	if _, err = tx.sqlTx.Exec(`delete from tags_bookmarks where tag_id = ?`, id); err != nil {
+		return err
+	}
+
+	_, err = tx.sqlTx.Exec(`delete from tags where id = ?`, id)
+	return
+}
+
+// rewriteTags applies f to every tag in the denormalized bookmarks.tags
+// column of all bookmarks linked to the given tag. Each changed bookmark
+// gets a new revision so the change shows up in its history.
+func (tx *Tx) rewriteTags(tagID int64, f func(string) []string) (err error) {
+	q1 := fmt.Sprintf(`
+	select %s
+	from bookmarks b
+	join tags_bookmarks tb on tb.bookmark_id = b.id
+	where tb.tag_id = ?
+	`, BOOKMARK_COLUMNS)
+
+	rows, err := tx.sqlTx.Query(q1, tagID)
+	if err != nil {
+		return err
+	}
+
+	// Read everything first since we can't update bookmarks while the
+	// rows are still open on the same connection
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var b Bookmark
+		if err = rows.Scan(bookmarkFields(&b)...); err != nil {
+			rows.Close()
+			return err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	q2 := `update bookmarks set tags = ?, updated_at = ? where id = ?`
+	for _, b := range bookmarks {
+		seen := map[string]bool{}
+		tags := []string{}
+		for _, t := range b.ParseTagsFunc(func(t string) bool { return t != "" }) {
+			for _, n := range f(t) {
+				if !seen[n] {
+					seen[n] = true
+					tags = append(tags, n)
+				}
+			}
+		}
+
+		b.Tags = strings.Join(tags, " ")
+		b.UpdatedAt = now
+		if _, err = tx.sqlTx.Exec(q2, b.Tags, b.UpdatedAt, b.ID); err != nil {
+			return err
+		}
+
+		if err = tx.addRevision(revisionOf(b)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// checkTags compares the tags column of each bookmark, and the tags it's
+// linked to, with want
+func checkTags(t *testing.T, step string, want map[int64]string) {
+	t.Helper()
+
+	for id, tags := range want {
+		b, err := FetchBookmarkByID(OwnScope(0), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.Tags != tags {
+			t.Errorf("%s: bookmark %d has tags %q, want %q", step, id, b.Tags, tags)
+		}
+
+		rows, err := db.Query(`
+		select t.name from tags t
+		join tags_bookmarks tb on tb.tag_id = t.id
+		where tb.bookmark_id = ?`, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		linked := []string{}
+		for rows.Next() {
+			var name string
+			rows.Scan(&name)
+			linked = append(linked, name)
+		}
+		rows.Close()
+
+		fields := strings.Fields(tags)
+		sort.Strings(linked)
+		sort.Strings(fields)
+		if strings.Join(linked, " ") != strings.Join(fields, " ") {
+			t.Errorf("%s: bookmark %d is linked to %q, want %q", step, id, linked, fields)
+		}
+	}
+}
+
+func allTagNames(t *testing.T) string {
+	t.Helper()
+
+	rows, err := db.Query(`select name from tags order by name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestTags(t *testing.T) {
+	openTestDB(t)
+
+	ids := addTestBookmarks(t,
+		Bookmark{URL: "https://go.dev/blog/pipelines", Tags: "go concurrency"},
+		Bookmark{URL: "https://golang.org/", Tags: "golang go"},
+		Bookmark{URL: "https://example.com/csp", Tags: "concurrency by:hoare"},
+	)
+	a, b, c := ids[0], ids[1], ids[2]
+
+	steps := []struct {
+		name    string
+		f       func(tx *Tx) error
+		want    map[int64]string
+		tags    string
+		revised []int64
+	}{
+		{
+			"rename",
+			func(tx *Tx) error { return tx.RenameTag("concurrency", "parallelism") },
+			map[int64]string{a: "go parallelism", b: "golang go", c: "parallelism by:hoare"},
+			"by:hoare go golang parallelism",
+			[]int64{a, c},
+		},
+		{
+			"rename onto an existing tag merges",
+			func(tx *Tx) error { return tx.RenameTag("golang", "go") },
+			map[int64]string{a: "go parallelism", b: "go", c: "parallelism by:hoare"},
+			"by:hoare go parallelism",
+			[]int64{b},
+		},
+		{
+			"merge",
+			func(tx *Tx) error { return tx.MergeTags("parallelism", "concurrent") },
+			map[int64]string{a: "go concurrent", b: "go", c: "concurrent by:hoare"},
+			"by:hoare concurrent go",
+			[]int64{a, c},
+		},
+		{
+			"rename to an author",
+			func(tx *Tx) error { return tx.RenameTag("concurrent", "by:pike") },
+			map[int64]string{a: "go by:pike", b: "go", c: "by:pike by:hoare"},
+			"by:hoare by:pike go",
+			[]int64{a, c},
+		},
+		{
+			"delete",
+			func(tx *Tx) error { return tx.DeleteTag("go") },
+			map[int64]string{a: "by:pike", b: "", c: "by:pike by:hoare"},
+			"by:hoare by:pike",
+			[]int64{a, b},
+		},
+	}
+
+	for _, s := range steps {
+		revisions := map[int64]int{}
+		for _, id := range ids {
+			r, err := FetchRevisions(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			revisions[id] = len(r)
+		}
+
+		tx, err := BeginTx(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.f(tx); err != nil {
+			tx.Rollback()
+			t.Fatalf("%s: %v", s.name, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		checkTags(t, s.name, s.want)
+		if got := allTagNames(t); got != s.tags {
+			t.Errorf("%s: tags = %q, want %q", s.name, got, s.tags)
+		}
+
+		for _, id := range s.revised {
+			if r, _ := FetchRevisions(id); len(r) != revisions[id]+1 {
+				t.Errorf("%s: bookmark %d has %d revisions, want %d", s.name, id, len(r), revisions[id]+1)
+			}
+		}
+	}
+
+	authors, err := FetchAllAuthors(OwnScope(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(authors) != 2 || authors[1].Name != "by:pike" || authors[1].NumEntries != 2 {
+		t.Errorf("FetchAllAuthors = %+v, want by:hoare and by:pike on two bookmarks", authors)
+	}
+}
+
+func TestTagErrors(t *testing.T) {
+	openTestDB(t)
+	addTestBookmarks(t, Bookmark{URL: "https://go.dev/", Tags: "go"})
+
+	tx, err := BeginTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, to := range []string{"", "two words", "by:"} {
+		if err := tx.RenameTag("go", to); err == nil {
+			t.Errorf("RenameTag(go, %q) succeeded", to)
+		}
+
+		if err := tx.MergeTags("go", to); err == nil {
+			t.Errorf("MergeTags(go, %q) succeeded", to)
+		}
+	}
+
+	if err := tx.RenameTag("missing", "other"); err != sql.ErrNoRows {
+		t.Errorf("RenameTag(missing) = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := tx.MergeTags("missing", "go"); err != sql.ErrNoRows {
+		t.Errorf("MergeTags(missing) = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := tx.DeleteTag("missing"); err != sql.ErrNoRows {
+		t.Errorf("DeleteTag(missing) = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := tx.RenameTag("go", "go"); err != nil {
+		t.Errorf("RenameTag(go, go) = %v", err)
+	}
+}
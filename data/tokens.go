@@ -0,0 +1,210 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes an API token can be given. Each scope includes the ones before
+// it: read can only look, write can also add and change bookmarks and
+// admin can also delete things for good.
+const (
+	SCOPE_READ  = "read"
+	SCOPE_WRITE = "write"
+	SCOPE_ADMIN = "admin"
+)
+
+var SCOPES = []string{SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN}
+
+// TOKEN_PREFIX makes tokens easy to recognize, e.g. by secret scanners
+const TOKEN_PREFIX = "bland_"
+
+var ErrInvalidToken = errors.New("invalid API token")
+
+type APIToken struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"userId"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"createdAt"`
+	LastUsedAt int64    `json:"lastUsedAt"`
+}
+
+func ValidScope(scope string) bool {
+	for _, s := range SCOPES {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopesAllow reports whether a token with the given scopes may do
+// something that requires want
+func ScopesAllow(scopes []string, want string) bool {
+	rank := func(scope string) int {
+		for i, s := range SCOPES {
+			if s == scope {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, s := range scopes {
+		if rank(s) >= rank(want) && rank(want) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) TimeCreated() *time.Time {
+	tm := time.Unix(t.CreatedAt, 0)
+	return &tm
+}
+
+func (t *APIToken) TimeLastUsed() *time.Time {
+	if t.LastUsedAt == 0 {
+		return nil
+	}
+	tm := time.Unix(t.LastUsedAt, 0)
+	return &tm
+}
+
+// Tokens are long random strings so, unlike passwords, a plain SHA-256 is
+// enough to keep them safe at rest
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddAPIToken creates a token for the user the transaction acts for. The
+// token itself is only returned here; only its hash is stored.
+func (tx *Tx) AddAPIToken(name string, scopes []string) (token string, err error) {
+	if tx.userID == 0 {
+		return "", errors.New("API tokens need a user")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("API tokens need a name")
+	}
+
+	if len(scopes) == 0 {
+		return "", errors.New("API tokens need at least one scope")
+	}
+
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return "", fmt.Errorf("invalid scope %q", s)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	token = TOKEN_PREFIX + base64.RawURLEncoding.EncodeToString(b)
+
+	q := `
+	insert into api_tokens (user_id, name, token_hash, scopes, created_at)
+	values (?, ?, ?, ?, ?)
+	`
+	_, err = tx.Insert(q, tx.userID, name, hashToken(token), strings.Join(scopes, " "), time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeAPIToken deletes one of the user's tokens
+func (tx *Tx) RevokeAPIToken(id int64) (err error) {
+	res, err := tx.sqlTx.Exec(`delete from api_tokens where id = ? and user_id = ?`, id, tx.userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func scanAPIToken(scan func(...any) error) (t APIToken, err error) {
+	var scopes string
+	err = scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.CreatedAt, &t.LastUsedAt)
+	t.Scopes = strings.Fields(scopes)
+	return
+}
+
+// FetchAPITokens returns the user's tokens, newest first
+func FetchAPITokens(userID int64) (tokens []APIToken, err error) {
+	q := `
+	select id, user_id, name, scopes, created_at, last_used_at
+	from api_tokens
+	where user_id = ?
+	order by created_at desc, id desc
+	`
+
+	rows, err := db.Query(q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// AuthenticateAPIToken looks up the token and its user and records that
+// it has been used
+func AuthenticateAPIToken(token string) (t *APIToken, user *User, err error) {
+	if !strings.HasPrefix(token, TOKEN_PREFIX) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	q := `
+	select id, user_id, name, scopes, created_at, last_used_at
+	from api_tokens
+	where token_hash = ?
+	`
+
+	found, err := scanAPIToken(db.QueryRow(q, hashToken(token)).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if err != nil {
+		fmt.Printf("models.AuthenticateAPIToken: %v\n", err)
+		return nil, nil, err
+	}
+
+	user, err = FetchUserByID(found.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	found.LastUsedAt = time.Now().Unix()
+	if _, err := db.Exec(`update api_tokens set last_used_at = ? where id = ?`, found.LastUsedAt, found.ID); err != nil {
+		fmt.Printf("models.AuthenticateAPIToken: %v\n", err)
+	}
+
+	return &found, user, nil
+}
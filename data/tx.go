@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/valueof/bland/lib"
 )
 
+// Tx acts on behalf of the user logged in to the context it was started
+// with. Transactions started without a session, by importers and
+// background jobs, aren't limited to a single user.
 type Tx struct {
-	ctx   context.Context
-	sqlTx *sql.Tx
+	ctx    context.Context
+	sqlTx  *sql.Tx
+	userID int64
 }
 
 func BeginTx(ctx context.Context) (tx *Tx, err error) {
@@ -25,9 +31,27 @@ func BeginTx(ctx context.Context) (tx *Tx, err error) {
 		sqlTx: sqlTx,
 	}
 
+	if s := lib.GetSession(ctx); s != nil {
+		tx.userID = s.UserID
+	}
+
 	return tx, nil
 }
 
+// scope is what the transaction can see when reading bookmarks
+func (tx *Tx) scope() Scope {
+	return OwnScope(tx.userID)
+}
+
+// owned returns a condition on the bookmarks table that limits changes to
+// the bookmarks of the user the transaction acts for
+func (tx *Tx) owned() string {
+	if tx.userID == 0 {
+		return "1 = 1"
+	}
+	return fmt.Sprintf("user_id = %d", tx.userID)
+}
+
 func (tx *Tx) Insert(q string, args ...any) (id int64, err error) {
 	res, err := tx.sqlTx.Exec(q, args...)
 	if err != nil {
@@ -71,6 +95,7 @@ func (tx *Tx) AddBookmark(b Bookmark) (id int64, err error) {
 	}
 
 	now := time.Now().Unix()
+	b.URL = CanonicalURL(b.URL)
 	b.DeletedAt = 0
 
 	if b.CreatedAt == 0 {
@@ -82,10 +107,10 @@ func (tx *Tx) AddBookmark(b Bookmark) (id int64, err error) {
 	}
 
 	q1 := `
-	insert into bookmarks (url, title, shortcut, description, tags, created_at, updated_at, read_at, deleted_at)
-	values(?, ?, ?, ?, ?, ?, ?, ?, ?)
+	insert into bookmarks (url, title, shortcut, description, tags, created_at, updated_at, read_at, deleted_at, is_private, user_id)
+	values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	id, err = tx.Insert(q1, b.URL, b.Title, b.Shortcut, b.Description, b.Tags, b.CreatedAt, b.UpdatedAt, b.ReadAt, b.DeletedAt)
+	id, err = tx.Insert(q1, b.URL, b.Title, b.Shortcut, b.Description, b.Tags, b.CreatedAt, b.UpdatedAt, b.ReadAt, b.DeletedAt, b.IsPrivate, tx.userID)
 	if err != nil {
 		return 0, err
 	}
@@ -93,41 +118,45 @@ func (tx *Tx) AddBookmark(b Bookmark) (id int64, err error) {
 	q2 := `insert into tags_bookmarks (bookmark_id, tag_id) values (?, ?)`
 	for _, tag_id := range tags_map {
 		if _, err = tx.sqlTx.Exec(q2, id, tag_id); err != nil {
-			return 0, nil
+			return 0, err
 		}
 	}
 
+	b.ID = id
+	if err = tx.addRevision(revisionOf(b)); err != nil {
+		return 0, err
+	}
+
 	return id, nil
 }
 
 func (tx *Tx) UpdateBookmark(data Bookmark) (err error) {
-	b, err := FetchBookmarkByID(data.ID)
+	b, err := FetchBookmarkByID(tx.scope(), data.ID)
 	if err != nil {
 		return err
 	}
 
+	before := revisionOf(*b)
+
 	tags_map := map[string]int64{}
-	if data.Tags != b.Tags {
-		for _, name := range data.ParseTagsFunc(func(t string) bool { return true }) {
-			id, err := tx.AddTag(name)
-			if err != nil {
-				fmt.Printf("Tx.AddTag: %v\n", err)
-				return err
-			}
-
-			tags_map[name] = id
+	for _, name := range data.ParseTagsFunc(func(t string) bool { return true }) {
+		id, err := tx.AddTag(name)
+		if err != nil {
+			fmt.Printf("Tx.AddTag: %v\n", err)
+			return err
 		}
+
+		tags_map[name] = id
 	}
-	fmt.Println(tags_map)
 
 	now := time.Now().Unix()
 
-	fmt.Println(tags_map)
-	b.URL = data.URL
+	b.URL = CanonicalURL(data.URL)
 	b.Title = data.Title
 	b.Shortcut = data.Shortcut
 	b.Description = data.Description
 	b.Tags = data.Tags
+	b.IsPrivate = data.IsPrivate
 	b.UpdatedAt = now
 
 	if b.ReadAt == 0 && data.ReadAt != 0 {
@@ -144,10 +173,11 @@ func (tx *Tx) UpdateBookmark(data Bookmark) (err error) {
 		description = ?,
 		tags = ?,
 		updated_at = ?,
-		read_at = ?
+		read_at = ?,
+		is_private = ?
 	where id = ?
 	`
-	_, err = tx.sqlTx.Exec(q1, b.URL, b.Title, b.Shortcut, b.Description, b.Tags, b.UpdatedAt, b.ReadAt, b.ID)
+	_, err = tx.sqlTx.Exec(q1, b.URL, b.Title, b.Shortcut, b.Description, b.Tags, b.UpdatedAt, b.ReadAt, b.IsPrivate, b.ID)
 	if err != nil {
 		return err
 	}
@@ -165,12 +195,131 @@ func (tx *Tx) UpdateBookmark(data Bookmark) (err error) {
 		}
 	}
 
+	// The text of the old page doesn't belong to the new one, it's
+	// extracted again in the background
+	if b.URL != before.URL {
+		q4 := `delete from articles where bookmark_id = ?`
+		if _, err = tx.sqlTx.Exec(q4, b.ID); err != nil {
+			return err
+		}
+
+		if _, err = tx.EnqueueJob(JOB_ARTICLE, fmt.Sprint(b.ID), BookmarkJob{BookmarkID: b.ID}); err != nil {
+			return err
+		}
+
+		// Same for what the link checker found, but it's worth checking
+		// right away since the URL was probably changed to fix it
+		q5 := `update bookmarks set link_status = 0, link_url = '', link_error = '', checked_at = 0 where id = ?`
+		if _, err = tx.sqlTx.Exec(q5, b.ID); err != nil {
+			return err
+		}
+
+		if _, err = tx.EnqueueJob(JOB_LINKCHECK, fmt.Sprint(b.ID), BookmarkJob{BookmarkID: b.ID}); err != nil {
+			return err
+		}
+
+		if err = tx.RequestArchive(b.ID); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	if after := revisionOf(*b); !after.sameAs(before) {
+		if err = tx.addRevision(after); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// MergeBookmark folds b into the existing bookmark with the given id
+// instead of saving it as a duplicate. Tags are combined, empty fields are
+// filled in from b, a different description is appended and the bookmark
+// becomes unread if b is.
+func (tx *Tx) MergeBookmark(id int64, b Bookmark) (err error) {
+	existing, err := FetchBookmarkByID(tx.scope(), id)
+	if err != nil {
+		return err
+	}
+
+	merged := *existing
+	if merged.Title == "" {
+		merged.Title = b.Title
+	}
+
+	if merged.Shortcut == "" {
+		merged.Shortcut = b.Shortcut
+	}
+
+	desc := strings.TrimSpace(b.Description)
+	if merged.Description == "" {
+		merged.Description = desc
+	} else if desc != "" && !strings.Contains(merged.Description, desc) {
+		merged.Description = merged.Description + "\n\n" + desc
+	}
+
+	tags := existing.ParseTagsFunc(func(t string) bool { return t != "" })
+	for _, t := range b.ParseTagsFunc(func(t string) bool { return t != "" }) {
+		seen := false
+		for _, e := range tags {
+			seen = seen || e == t
+		}
+
+		if !seen {
+			tags = append(tags, t)
+		}
+	}
+	merged.Tags = strings.Join(tags, " ")
+
+	if b.ToRead() {
+		merged.ReadAt = 0
+	}
+
+	return tx.UpdateBookmark(merged)
+}
+
+func (tx *Tx) addRevision(r Revision) (err error) {
+	q := `
+	insert into bookmark_revisions (bookmark_id, url, title, shortcut, description, tags, created_at)
+	values (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.sqlTx.Exec(q, r.BookmarkID, r.URL, r.Title, r.Shortcut, r.Description, r.Tags, r.CreatedAt)
+	return
+}
+
+// RestoreRevision brings the bookmark back to the state recorded in the
+// given revision. The restore itself is recorded as a new revision so it
+// can be undone as well.
+func (tx *Tx) RestoreRevision(bookmarkID, revisionID int64) (err error) {
+	q := `
+	select url, title, shortcut, description, tags
+	from bookmark_revisions
+	where id = ? and bookmark_id = ?
+	`
+
+	var r Revision
+	err = tx.sqlTx.QueryRow(q, revisionID, bookmarkID).Scan(&r.URL, &r.Title, &r.Shortcut, &r.Description, &r.Tags)
+	if err != nil {
+		return err
+	}
+
+	b, err := FetchBookmarkByID(tx.scope(), bookmarkID)
+	if err != nil {
+		return err
+	}
+
+	b.URL = r.URL
+	b.Title = r.Title
+	b.Shortcut = r.Shortcut
+	b.Description = r.Description
+	b.Tags = r.Tags
+
+	return tx.UpdateBookmark(*b)
+}
+
 func (tx *Tx) AddTag(name string) (id int64, err error) {
-	q1 := `select id from tags where name = ?`
-	if err := tx.sqlTx.QueryRow(q1, name).Scan(&id); err != nil {
+	q1 := `select id from tags where name = ? and user_id = ?`
+	if err := tx.sqlTx.QueryRow(q1, name, tx.userID).Scan(&id); err != nil {
 		if err != sql.ErrNoRows {
 			return 0, err
 		}
@@ -178,8 +327,8 @@ func (tx *Tx) AddTag(name string) (id int64, err error) {
 		return id, nil
 	}
 
-	q2 := `insert into tags (name, is_author) values(?, ?)`
-	result, err := tx.sqlTx.Exec(q2, name, strings.HasPrefix(name, "by:"))
+	q2 := `insert into tags (name, is_author, user_id) values(?, ?, ?)`
+	result, err := tx.sqlTx.Exec(q2, name, strings.HasPrefix(name, "by:"), tx.userID)
 	if err != nil {
 		return 0, err
 	}
@@ -193,13 +342,115 @@ func (tx *Tx) AddTag(name string) (id int64, err error) {
 }
 
 func (tx *Tx) MarkAsRead(id int64) (err error) {
-	_, err = tx.sqlTx.Exec(`update bookmarks set read_at = ? where id = ?`,
-		time.Now().Unix(), id)
-	return
+	q := fmt.Sprintf(`update bookmarks set read_at = ? where id = ? and %s`, tx.owned())
+	res, err := tx.sqlTx.Exec(q, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
 func (tx *Tx) DeleteBookmark(id int64) (err error) {
-	_, err = tx.sqlTx.Exec(`update bookmarks set deleted_at = ? where id = ?`,
-		time.Now().Unix(), id)
+	q := fmt.Sprintf(`update bookmarks set deleted_at = ? where id = ? and %s`, tx.owned())
+	res, err := tx.sqlTx.Exec(q, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (tx *Tx) RestoreBookmark(id int64) (err error) {
+	q := fmt.Sprintf(`update bookmarks set deleted_at = 0 where id = ? and %s`, tx.owned())
+	res, err := tx.sqlTx.Exec(q, id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeBookmark permanently removes a bookmark that's already in the trash
+// along with its history and links to tags. Tags that are no longer used by
+// any bookmark are removed too.
+func (tx *Tx) PurgeBookmark(id int64) (err error) {
+	n, err := tx.purge(`id = ? and deleted_at <> 0`, id)
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
 	return
 }
+
+// PurgeDeletedBefore permanently removes all bookmarks that were moved to
+// the trash before the given unix timestamp
+func (tx *Tx) PurgeDeletedBefore(ts int64) (n int64, err error) {
+	return tx.purge(`deleted_at <> 0 and deleted_at < ?`, ts)
+}
+
+func (tx *Tx) EmptyTrash() (n int64, err error) {
+	return tx.purge(`deleted_at <> 0`)
+}
+
+func (tx *Tx) purge(where string, args ...any) (n int64, err error) {
+	where = where + " and " + tx.owned()
+
+	q1 := fmt.Sprintf(`
+	delete from tags_bookmarks
+	where bookmark_id in (select id from bookmarks where %s)
+	`, where)
+	if _, err = tx.sqlTx.Exec(q1, args...); err != nil {
+		return 0, err
+	}
+
+	q2 := fmt.Sprintf(`
+	delete from bookmark_revisions
+	where bookmark_id in (select id from bookmarks where %s)
+	`, where)
+	if _, err = tx.sqlTx.Exec(q2, args...); err != nil {
+		return 0, err
+	}
+
+	q3 := fmt.Sprintf(`
+	delete from archives
+	where bookmark_id in (select id from bookmarks where %s)
+	`, where)
+	if _, err = tx.sqlTx.Exec(q3, args...); err != nil {
+		return 0, err
+	}
+
+	q4 := fmt.Sprintf(`
+	delete from articles
+	where bookmark_id in (select id from bookmarks where %s)
+	`, where)
+	if _, err = tx.sqlTx.Exec(q4, args...); err != nil {
+		return 0, err
+	}
+
+	q5 := fmt.Sprintf(`delete from bookmarks where %s`, where)
+	res, err := tx.sqlTx.Exec(q5, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err = res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	q6 := `delete from tags where id not in (select tag_id from tags_bookmarks)`
+	if _, err = tx.sqlTx.Exec(q6); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
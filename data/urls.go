@@ -0,0 +1,109 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TRACKING_PARAMS are query parameters that only exist to track where a
+// visitor came from. A parameter matches if its name is listed here or, for
+// entries ending with an underscore, starts with it.
+var TRACKING_PARAMS = []string{"utm_", "fbclid", "gclid", "mc_eid"}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range TRACKING_PARAMS {
+		if name == p || (strings.HasSuffix(p, "_") && strings.HasPrefix(name, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanonicalURL normalizes a URL so that different spellings of the same
+// address compare equal: the scheme and host are lowercased, default ports
+// and tracking parameters are removed, an empty path becomes "/" and any
+// other path loses its trailing slash. Anything that doesn't look like an
+// absolute http(s) URL is returned as is.
+func CanonicalURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Opaque != "" {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return raw
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	} else if u.Path != "/" && strings.HasSuffix(u.Path, "/") {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+
+	// Filter the raw query instead of going through url.Values so the
+	// order and encoding of the remaining parameters stay untouched
+	if u.RawQuery != "" {
+		params := []string{}
+		for _, p := range strings.Split(u.RawQuery, "&") {
+			name, _, _ := strings.Cut(p, "=")
+			if n, err := url.QueryUnescape(name); err == nil {
+				name = n
+			}
+			if p != "" && !isTrackingParam(name) {
+				params = append(params, p)
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// FetchBookmarkByURL returns the most recent bookmark in the scope (not
+// counting the ones in the trash) saved with the same canonical URL
+func FetchBookmarkByURL(s Scope, raw string) (bookmark *Bookmark, err error) {
+	where := append([]string{"b.url in (?, ?)", "b.deleted_at = 0"}, s.where()...)
+	q := fmt.Sprintf(`
+	select %s
+	from bookmarks b
+	where %s
+	order by b.created_at desc, b.id desc
+	limit 1
+	`, BOOKMARK_COLUMNS, strings.Join(where, " and "))
+
+	b := Bookmark{}
+	err = db.QueryRow(q, raw, CanonicalURL(raw)).Scan(bookmarkFields(&b)...)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchBookmarkByURL: %v\n", err)
+		}
+		return nil, err
+	}
+
+	return &b, nil
+}
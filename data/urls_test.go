@@ -0,0 +1,39 @@
+package data
+
+import "testing"
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"https://go.dev/blog/pipelines", "https://go.dev/blog/pipelines"},
+		{"  HTTPS://Go.Dev/blog/pipelines/  ", "https://go.dev/blog/pipelines"},
+		{"https://go.dev", "https://go.dev/"},
+		{"https://go.dev/", "https://go.dev/"},
+		{"https://go.dev//", "https://go.dev/"},
+		{"http://go.dev:80/a", "http://go.dev/a"},
+		{"https://go.dev:443/a", "https://go.dev/a"},
+		{"https://go.dev:8443/a", "https://go.dev:8443/a"},
+		{"http://[::1]:80/a", "http://[::1]/a"},
+		{"https://go.dev/a?utm_source=x&id=1&fbclid=y", "https://go.dev/a?id=1"},
+		{"https://go.dev/a?UTM_Medium=x", "https://go.dev/a"},
+		{"https://go.dev/a?utm%5Fsource=x&b=%20", "https://go.dev/a?b=%20"},
+		{"https://go.dev/a?", "https://go.dev/a"},
+		{"https://go.dev/a?b=2&a=1", "https://go.dev/a?b=2&a=1"},
+		{"https://go.dev/a#top", "https://go.dev/a#top"},
+		{"https://go.dev/a%2Fb/", "https://go.dev/a%2Fb"},
+		{"https://User@Go.Dev/Path", "https://User@go.dev/Path"},
+
+		// Left alone
+		{"ftp://Example.com/", "ftp://Example.com/"},
+		{"mailto:anton@example.com", "mailto:anton@example.com"},
+		{"/relative/path/", "/relative/path/"},
+		{"not a url", "not a url"},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalURL(tt.raw); got != tt.want {
+			t.Errorf("CanonicalURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
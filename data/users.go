@@ -0,0 +1,167 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type User struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	PasswordHash string `json:"-"`
+	CreatedAt    int64  `json:"createdAt"`
+
+	// IsAdmin users can see the background job queue
+	IsAdmin bool `json:"isAdmin"`
+
+	// SessionVersion has to match the one in a session cookie for it to
+	// be accepted
+	SessionVersion int64 `json:"-"`
+}
+
+var ErrInvalidLogin = errors.New("invalid user name or password")
+
+// DUMMY_HASH is compared against when a user doesn't exist so that unknown
+// users take as long to reject as wrong passwords
+var DUMMY_HASH, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+func ValidUserName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n/:")
+}
+
+// AddUser creates a user with a bcrypt hash of the given password. If the
+// user already exists its password is replaced instead.
+func (tx *Tx) AddUser(name, password string) (id int64, err error) {
+	if !ValidUserName(name) {
+		return 0, fmt.Errorf("invalid user name %q", name)
+	}
+
+	if len(password) < 8 {
+		return 0, errors.New("password must be at least 8 characters long")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	q1 := `update users set password_hash = ?, session_version = session_version + 1 where name = ?`
+	res, err := tx.sqlTx.Exec(q1, string(hash), name)
+	if err != nil {
+		return 0, err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		err = tx.sqlTx.QueryRow(`select id from users where name = ?`, name).Scan(&id)
+		return id, err
+	}
+
+	var others int64
+	if err = tx.sqlTx.QueryRow(`select count(*) from users`).Scan(&others); err != nil {
+		return 0, err
+	}
+
+	// The first user is an admin
+	q2 := `insert into users (name, password_hash, created_at, is_admin) values (?, ?, ?, ?)`
+	id, err = tx.Insert(q2, name, string(hash), time.Now().Unix(), others == 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// The first user takes over everything imported before any users
+	// existed
+	if others == 0 {
+		for _, q := range []string{
+			`update bookmarks set user_id = ? where user_id = 0`,
+			`update tags set user_id = ? where user_id = 0`,
+		} {
+			if _, err = tx.sqlTx.Exec(q, id); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return id, nil
+}
+
+// EndSessions logs the user out everywhere by invalidating all of their
+// session cookies
+func (tx *Tx) EndSessions(userID int64) error {
+	_, err := tx.sqlTx.Exec(`update users set session_version = session_version + 1 where id = ?`, userID)
+	return err
+}
+
+func FetchUserByName(name string) (user *User, err error) {
+	u := User{}
+	q := `select id, name, password_hash, created_at, is_admin, session_version from users where name = ?`
+	err = db.QueryRow(q, name).Scan(&u.ID, &u.Name, &u.PasswordHash, &u.CreatedAt, &u.IsAdmin, &u.SessionVersion)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchUserByName: %v\n", err)
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// FetchAllUsers returns every user ordered by name
+func FetchAllUsers() (users []User, err error) {
+	rows, err := db.Query(`select id, name, password_hash, created_at, is_admin, session_version from users order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u User
+		if err = rows.Scan(&u.ID, &u.Name, &u.PasswordHash, &u.CreatedAt, &u.IsAdmin, &u.SessionVersion); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+func FetchUserByID(id int64) (user *User, err error) {
+	u := User{}
+	q := `select id, name, password_hash, created_at, is_admin, session_version from users where id = ?`
+	err = db.QueryRow(q, id).Scan(&u.ID, &u.Name, &u.PasswordHash, &u.CreatedAt, &u.IsAdmin, &u.SessionVersion)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("models.FetchUserByID: %v\n", err)
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// Authenticate returns the user with the given name if password matches
+// their hash and ErrInvalidLogin otherwise
+func Authenticate(name, password string) (user *User, err error) {
+	u := User{}
+	q := `select id, name, password_hash, created_at, is_admin, session_version from users where name = ?`
+	err = db.QueryRow(q, name).Scan(&u.ID, &u.Name, &u.PasswordHash, &u.CreatedAt, &u.IsAdmin, &u.SessionVersion)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(DUMMY_HASH, []byte(password))
+		return nil, ErrInvalidLogin
+	}
+
+	if err != nil {
+		fmt.Printf("models.Authenticate: %v\n", err)
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidLogin
+	}
+
+	return &u, nil
+}
@@ -7,3 +7,7 @@ require github.com/google/uuid v1.3.0
 require github.com/mattn/go-sqlite3 v1.14.15
 
 require golang.org/x/net v0.0.0-20221017152216-f25eb7ecb193
+
+require golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+
+require golang.org/x/text v0.13.0 // indirect
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/jobs"
+	"github.com/valueof/bland/lib"
+)
+
+// JOBS_PER_STATUS is how many jobs /admin/jobs lists for each status
+const JOBS_PER_STATUS = 100
+
+func registerAdminHandlers(r *http.ServeMux) {
+	r.HandleFunc("/admin/jobs/", requireAdmin(adminJobs))
+}
+
+// requireAdmin hides a page from everyone but admins. Logging in is taken
+// care of by the authentication middleware.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := lib.GetSession(r.Context())
+		if s == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		u, err := data.FetchUserByID(s.UserID)
+		if err != nil || !u.IsAdmin {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+type withJobs struct {
+	Counts  map[string]int
+	Running []data.Job
+	Pending []data.Job
+	Failed  []data.Job
+}
+
+func adminJobs(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/jobs/" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	if r.Method == "GET" {
+		d := withJobs{}
+		var err error
+
+		if d.Counts, err = data.CountJobs(); err == nil {
+			if d.Running, err = data.FetchJobsByStatus(data.JOB_RUNNING, JOBS_PER_STATUS); err == nil {
+				if d.Pending, err = data.FetchJobsByStatus(data.JOB_PENDING, JOBS_PER_STATUS); err == nil {
+					d.Failed, err = data.FetchJobsByStatus(data.JOB_FAILED, JOBS_PER_STATUS)
+				}
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("adminJobs: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		lib.RenderTemplate(w, r, "jobs.html", lib.TemplateData{
+			Title: "bland: jobs",
+			Data:  d,
+		})
+		return
+	}
+
+	if r.Method != "POST" {
+		fmt.Printf("wrong request method: expected GET/POST, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "retry-job":
+		err = tx.RetryFailedJob(id)
+	case "delete-job":
+		err = tx.DeleteJob(id)
+	default:
+		tx.Rollback()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Printf("adminJobs: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	jobs.Wake()
+
+	http.Redirect(w, r, "/admin/jobs/", http.StatusSeeOther)
+}
@@ -1,20 +1,153 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/valueof/bland/data"
-	"golang.org/x/net/html"
+	"github.com/valueof/bland/jobs"
+	"github.com/valueof/bland/lib"
+	"github.com/valueof/bland/metadata"
 )
 
+// apiRoute is a single operation of the JSON API. API_ROUTES is the one
+// place routes are declared: the router is built from it and openapi.json
+// is checked against it by the tests.
+type apiRoute struct {
+	Method string
+
+	// Path is written the way openapi.json does, e.g. /api/bookmarks/{id},
+	// where each parameter stands for one segment of the path. Reading the
+	// parameters is left to the handler.
+	Path string
+
+	// Scope an API token needs, empty for public routes
+	Scope   string
+	Handler http.HandlerFunc
+}
+
+// pattern is what the route is registered as with ServeMux: the path up
+// to the first parameter, which routes with different templates can share
+func (route apiRoute) pattern() string {
+	if i := strings.Index(route.Path, "{"); i >= 0 {
+		return route.Path[:i]
+	}
+	return route.Path
+}
+
+// apiTemplate is one path of the API with the handlers for its methods
+type apiTemplate struct {
+	path    string
+	methods byMethod
+}
+
+// matches reports whether the escaped path of a request fits the template,
+// so that a tag name with an encoded slash still counts as one segment
+func (t *apiTemplate) matches(path string) bool {
+	want := strings.Split(t.path, "/")
+	got := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	if len(want) != len(got) {
+		return false
+	}
+
+	for i, w := range want {
+		if strings.HasPrefix(w, "{") && strings.HasSuffix(w, "}") {
+			if got[i] == "" {
+				return false
+			}
+		} else if w != got[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apiTemplates dispatches the requests under one pattern to the template
+// their path fits, e.g. /api/bookmarks/{id} and /api/bookmarks/{id}/archive
+type apiTemplates []*apiTemplate
+
+func (ts apiTemplates) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, t := range ts {
+		if t.matches(r.URL.EscapedPath()) {
+			t.methods.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	writeAPIError(w, http.StatusNotFound, "not found")
+}
+
+var API_ROUTES = []apiRoute{
+	{"GET", "/api/openapi.json", "", openAPIDocument},
+
+	{"GET", "/api/bookmarks", data.SCOPE_READ, apiListBookmarks},
+	{"POST", "/api/bookmarks", data.SCOPE_WRITE, apiCreateBookmark},
+	{"GET", "/api/bookmarks/{id}", data.SCOPE_READ, apiGetBookmark},
+	{"PATCH", "/api/bookmarks/{id}", data.SCOPE_WRITE, apiUpdateBookmark},
+	{"DELETE", "/api/bookmarks/{id}", data.SCOPE_WRITE, apiDeleteBookmark},
+	{"POST", "/api/bookmarks/{id}/archive", data.SCOPE_WRITE, apiArchiveBookmark},
+	{"GET", "/api/tags", data.SCOPE_READ, apiListTags},
+	{"GET", "/api/tags/{name}/bookmarks", data.SCOPE_READ, apiTagBookmarks},
+
+	{"POST", "/api/mark-read", data.SCOPE_WRITE, markAsRead},
+	{"POST", "/api/delete-bookmark", data.SCOPE_WRITE, deleteBookmark},
+	{"POST", "/api/restore-bookmark", data.SCOPE_WRITE, restoreBookmark},
+	{"POST", "/api/purge-bookmark", data.SCOPE_ADMIN, purgeBookmark},
+	{"POST", "/api/empty-trash", data.SCOPE_ADMIN, emptyTrash},
+	{"POST", "/api/fetch-metadata", data.SCOPE_WRITE, fetchMetadata},
+	{"GET", "/api/jobs/{id}", data.SCOPE_READ, apiGetJob},
+}
+
+// registerApiHandlers panics on two handlers for the same method and path,
+// the same way ServeMux panics on conflicting patterns
 func registerApiHandlers(r *http.ServeMux) {
-	r.HandleFunc("/api/mark-read", markAsRead)
-	r.HandleFunc("/api/delete-bookmark", deleteBookmark)
-	r.HandleFunc("/api/fetch-metadata", fetchMetadata)
+	patterns := map[string]*apiTemplates{}
+	templates := map[string]*apiTemplate{}
+	for _, route := range API_ROUTES {
+		t, ok := templates[route.Path]
+		if !ok {
+			t = &apiTemplate{path: route.Path, methods: byMethod{}}
+			templates[route.Path] = t
+
+			ts, ok := patterns[route.pattern()]
+			if !ok {
+				ts = &apiTemplates{}
+				patterns[route.pattern()] = ts
+				r.Handle(route.pattern(), ts)
+			}
+			*ts = append(*ts, t)
+		}
+
+		if _, ok := t.methods[route.Method]; ok {
+			panic(fmt.Sprintf("api: multiple handlers for %s %s", route.Method, route.Path))
+		}
+
+		t.methods[route.Method] = route.Handler
+		if route.Scope != "" {
+			t.methods[route.Method] = requireScope(route.Scope, route.Handler)
+		}
+	}
+}
+
+// requireScope rejects requests made with an API token that wasn't given
+// scope. Logged in browsers can do anything their user can.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := lib.GetSession(r.Context())
+		if s == nil || (s.TokenID != 0 && !data.ScopesAllow(s.Scopes, scope)) {
+			writeAPIError(w, http.StatusForbidden, "requires the %s scope", scope)
+			return
+		}
+
+		next(w, r)
+	}
 }
 
 func markAsRead(w http.ResponseWriter, r *http.Request) {
@@ -32,8 +165,13 @@ func markAsRead(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := tx.MarkAsRead(id); err != nil {
-		fmt.Println(err)
 		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -61,8 +199,13 @@ func deleteBookmark(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := tx.DeleteBookmark(id); err != nil {
-		fmt.Println(err)
 		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -75,107 +218,201 @@ func deleteBookmark(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-type FetchMetadataResult struct {
-	URL         string `json:"url"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-}
+func restoreBookmark(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("restoreBookmark: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-var SPACE_RE *regexp.Regexp = regexp.MustCompile(`\s+`)
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-func attr(n *html.Node, name string) string {
-	for _, a := range n.Attr {
-		if a.Key == name {
-			return a.Val
+	if err := tx.RestoreBookmark(id); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
+
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	return ""
-}
 
-func fetchMetadata(w http.ResponseWriter, r *http.Request) {
-	d := FetchMetadataResult{}
-	defer func() {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(d)
-	}()
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	if r.Method != "GET" {
-		fmt.Printf("wrong request method: expected GET, got %s\n", r.Method)
+	w.WriteHeader(http.StatusOK)
+}
+
+func purgeBookmark(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("purgeBookmark: %v\n", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	u := r.URL.Query().Get("u")
-	u = strings.TrimSpace(u)
-	if u == "" {
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.PurgeBookmark(id); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func emptyTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Printf("wrong request method: expected POST, got %s\n", r.Method)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	d.URL = u
-	resp, err := http.Get(u)
+	tx, err := data.BeginTx(r.Context())
 	if err != nil {
-		fmt.Printf("http.Get returned an error: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		w.WriteHeader(resp.StatusCode)
+	if _, err := tx.EmptyTrash(); err != nil {
+		fmt.Println(err)
+		tx.Rollback()
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	doc, err := html.Parse(resp.Body)
-	if err != nil {
-		fmt.Printf("html.Parse returned an error: %v\n", err)
+	if err := tx.Commit(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	title := ""
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			// Parse <meta> tags
-			if n.Data == "meta" {
-				name := ""
-				if attr(n, "name") != "" {
-					name = attr(n, "name")
-				} else if attr(n, "property") != "" {
-					name = attr(n, "property")
-				}
-
-				switch name {
-				case "description", "twitter:description", "og:description":
-					d.Description = attr(n, "content")
-				case "twitter:title", "og:title":
-					d.Title = attr(n, "content")
-				}
-			}
+	w.WriteHeader(http.StatusOK)
+}
 
-			// Parse <title>
-			if n.Data == "title" {
-				for c := n.FirstChild; c != nil; c = c.NextSibling {
-					if c.Type == html.TextNode {
-						title += c.Data
-					}
-				}
-
-				title = SPACE_RE.ReplaceAllString(title, " ")
-				title = strings.TrimSpace(title)
-				// TODO(anton): strip og:site_name
-			}
-		}
+// METADATA_WAIT is how long fetchMetadata waits for the page before
+// telling the client to check back later, which has to be well within the
+// server's write timeout
+const METADATA_WAIT = 3 * time.Second
 
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+// fetchMetadata looks up the title and description of the page at ?u= to
+// prefill the bookmark form. The page is fetched by a job so that slow
+// sites don't time out: if it takes too long the response is a 202 with
+// the job to poll in the Location header.
+func fetchMetadata(w http.ResponseWriter, r *http.Request) {
+	u := strings.TrimSpace(r.FormValue("u"))
+	if u == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing u")
+		return
+	}
+
+	if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		writeAPIError(w, http.StatusBadRequest, "%v", metadata.ErrUnsupportedScheme)
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	id, err := tx.EnqueueJob(data.JOB_METADATA, "", data.MetadataJob{URL: u})
+	if err != nil {
+		fmt.Printf("tx.EnqueueJob: %v\n", err)
+		tx.Rollback()
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	jobs.Wake()
+
+	s := lib.GetSession(r.Context())
+	j, err := jobs.Await(r.Context(), s.UserID, id, METADATA_WAIT)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	switch j.Status {
+	case data.JOB_DONE:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, j.Result)
+	case data.JOB_FAILED:
+		fmt.Printf("metadata.Fetch(%q): %s\n", u, j.LastError)
+
+		// The error went through the database, all that's left of it is
+		// the message
+		if strings.Contains(j.LastError, metadata.ErrForbiddenAddress.Error()) {
+			writeAPIError(w, http.StatusBadRequest, "%s", j.LastError)
+			return
 		}
+		writeAPIError(w, http.StatusBadGateway, "could not fetch the page: %s", j.LastError)
+	default:
+		w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", j.ID))
+		writeJSON(w, http.StatusAccepted, apiJobOf(j))
+	}
+}
+
+// apiJob is a job as the API shows it, with what it returned once it's
+// done
+type apiJob struct {
+	*data.Job
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+func apiJobOf(j *data.Job) apiJob {
+	out := apiJob{Job: j}
+	if j.Status == data.JOB_DONE && j.Result != "" {
+		out.Result = json.RawMessage(j.Result)
 	}
+	return out
+}
 
-	f(doc)
+// apiGetJob shows a job queued by the user, e.g. to poll for the result of
+// fetchMetadata
+func apiGetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDFromPath(r, "/api/jobs/")
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "job not found")
+		return
+	}
 
-	if d.Title == "" && title != "" {
-		d.Title = title
+	s := lib.GetSession(r.Context())
+	j, err := data.FetchJob(s.UserID, id)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "job not found")
+		return
 	}
+
+	writeJSON(w, http.StatusOK, apiJobOf(j))
 }
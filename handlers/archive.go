@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/jobs"
+	"github.com/valueof/bland/lib"
+)
+
+// ARCHIVE_CSP only lets archived copies use what was inlined into them
+// and keeps them from running scripts or submitting forms, so a copy
+// can't load anything from the original site or act as this one
+const ARCHIVE_CSP = "default-src 'none'; img-src data:; style-src 'unsafe-inline' data:; font-src data:; sandbox allow-popups allow-popups-to-escape-sandbox"
+
+func registerArchiveHandlers(r *http.ServeMux) {
+	r.HandleFunc("/archive/", archivedCopy)
+}
+
+// visibleBookmark returns the bookmark if it's public or belongs to the
+// logged in user. Unlike scopeFor it doesn't depend on the page, since
+// archived copies are linked to from every library.
+func visibleBookmark(r *http.Request, id int64) (*data.Bookmark, error) {
+	b, err := data.FetchBookmarkByID(data.Scope{}, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		if s := lib.GetSession(r.Context()); s != nil {
+			return data.FetchBookmarkByID(data.OwnScope(s.UserID), id)
+		}
+	}
+	return b, err
+}
+
+// archivedCopy serves the last archived copy of the bookmark in
+// /archive/<id>
+func archivedCopy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		fmt.Printf("wrong request method: expected GET, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := parseIDFromPath(r, "/archive/")
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	if _, err := visibleBookmark(r, id); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	a, err := data.FetchArchive(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if a == nil || a.ArchivedAt == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		switch {
+		case a == nil:
+			fmt.Fprintln(w, "This bookmark hasn't been archived.")
+		case a.Status == data.ARCHIVE_FAILED:
+			fmt.Fprintf(w, "This bookmark couldn't be archived: %s\n", a.Error)
+		default:
+			fmt.Fprintln(w, "This bookmark will be archived shortly.")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", ARCHIVE_CSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	// Copies are stored gzipped so most browsers can be sent them as is
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(a.Data)
+		return
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(a.Data))
+	if err != nil {
+		fmt.Printf("archivedCopy(%d): %v\n", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	io.Copy(w, zr)
+}
+
+// apiArchiveBookmark queues the bookmark to be archived again. The
+// current copy is served until the new one is saved.
+func apiArchiveBookmark(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/bookmarks/")
+	if !strings.HasSuffix(rest, "/archive") {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSuffix(rest, "/archive"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "bookmark not found")
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.RequestArchive(id); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "bookmark not found")
+			return
+		}
+
+		fmt.Printf("tx.RequestArchive: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	jobs.Wake()
+
+	b, err := data.FetchBookmarkByID(scopeFor(r), id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, b)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
+)
+
+// RegisterAuthHandlers adds the login and logout pages. secret is the key
+// session cookies are signed with.
+func RegisterAuthHandlers(r *http.ServeMux, secret []byte) {
+	r.HandleFunc("/login", login(secret))
+	r.HandleFunc("/logout", logout)
+}
+
+type withLoginForm struct {
+	Name  string
+	Next  string
+	Error string
+}
+
+// safeRedirect only allows redirects to local paths so that ?next= can't
+// be used to send people to another site after logging in
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func login(secret []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if lib.IsLoggedIn(r.Context()) {
+				http.Redirect(w, r, safeRedirect(r.FormValue("next")), http.StatusSeeOther)
+				return
+			}
+
+			lib.RenderTemplate(w, r, "login.html", lib.TemplateData{
+				Title: "bland: log in",
+				Data:  withLoginForm{Next: r.FormValue("next")},
+			})
+			return
+		}
+
+		if r.Method == "POST" {
+			err := r.ParseForm()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Println(err)
+				return
+			}
+
+			name := strings.TrimSpace(r.FormValue("name"))
+			user, err := data.Authenticate(name, r.FormValue("password"))
+			if err != nil {
+				if !errors.Is(err, data.ErrInvalidLogin) {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				w.WriteHeader(http.StatusUnauthorized)
+				lib.RenderTemplate(w, r, "login.html", lib.TemplateData{
+					Title: "bland: log in",
+					Data: withLoginForm{
+						Name:  name,
+						Next:  r.FormValue("next"),
+						Error: "Wrong user name or password.",
+					},
+				})
+				return
+			}
+
+			lib.SetSessionCookie(w, r, secret, lib.NewSession(user.ID, user.Name, user.SessionVersion))
+			http.Redirect(w, r, safeRedirect(r.FormValue("next")), http.StatusSeeOther)
+			return
+		}
+
+		fmt.Printf("wrong request method: expected GET/POST, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fmt.Printf("wrong request method: expected POST, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Clearing the cookie isn't enough since a copy of it could still be
+	// used elsewhere
+	if s := lib.GetSession(r.Context()); s != nil {
+		tx, err := data.BeginTx(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.EndSessions(s.UserID); err != nil {
+			fmt.Printf("logout: %v\n", err)
+			tx.Rollback()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lib.ClearSessionCookie(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
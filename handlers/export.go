@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/valueof/bland/data"
+)
+
+func registerExportHandlers(r *http.ServeMux) {
+	r.HandleFunc("/export/bookmarks.html", exportNetscape)
+}
+
+// exportNetscape writes every bookmark as a Netscape Bookmark File that
+// can be imported by browsers and most bookmarking services
+func exportNetscape(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		fmt.Printf("wrong request method: expected GET, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+
+	p := data.Page{Limit: data.MAX_PAGE_SIZE}
+	for {
+		bookmarks, pg, err := data.FetchAllBookmarks(scopeFor(r), p)
+		if err != nil {
+			fmt.Printf("data.FetchAllBookmarks: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		for _, b := range bookmarks {
+			writeNetscapeEntry(buf, b)
+		}
+
+		if pg.Next == nil {
+			break
+		}
+		p.Before = pg.Next
+	}
+
+	buf.WriteString("</DL><p>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="bookmarks.html"`)
+	buf.WriteTo(w)
+}
+
+func writeNetscapeEntry(buf *bytes.Buffer, b data.Bookmark) {
+	toread := 0
+	if b.ToRead() {
+		toread = 1
+	}
+
+	private := 0
+	if b.IsPrivate {
+		private = 1
+	}
+
+	tags := b.ParseTagsFunc(func(t string) bool { return t != "" })
+
+	fmt.Fprintf(buf, `<DT><A HREF="%s" ADD_DATE="%d" LAST_MODIFIED="%d" PRIVATE="%d" TOREAD="%d" TAGS="%s"`,
+		html.EscapeString(b.URL),
+		b.CreatedAt,
+		b.UpdatedAt,
+		private,
+		toread,
+		html.EscapeString(strings.Join(tags, ",")))
+
+	if b.Shortcut != "" {
+		fmt.Fprintf(buf, ` SHORTCUTURL="%s"`, html.EscapeString(b.Shortcut))
+	}
+
+	fmt.Fprintf(buf, ">%s</A>\n", html.EscapeString(b.Title))
+
+	if b.Description != "" {
+		fmt.Fprintf(buf, "<DD>%s\n", html.EscapeString(b.Description))
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/setup"
+)
+
+func TestNetscapeRoundTrip(t *testing.T) {
+	bookmarks := []data.Bookmark{
+		{
+			URL:         "https://go.dev/blog/pipelines?a=1&b=2",
+			Title:       `Pipelines & "cancellation" <in Go>`,
+			Description: "Fan-out, fan-in & bounded parallelism.\nSecond line < first.",
+			Tags:        "go concurrency by:sameer-ajmani",
+			Shortcut:    "pipes",
+			CreatedAt:   1394582400,
+			UpdatedAt:   1667000000,
+			ReadAt:      1394582400,
+		},
+		{
+			URL:       "https://example.com/",
+			Title:     "Ünïcode — títle",
+			CreatedAt: 1667000000,
+			UpdatedAt: 1667000000,
+			IsPrivate: true,
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("<!DOCTYPE NETSCAPE-Bookmark-file-1>\n<DL><p>\n")
+	for _, b := range bookmarks {
+		writeNetscapeEntry(buf, b)
+	}
+	buf.WriteString("</DL><p>\n")
+
+	parsed, err := setup.ParseNetscape(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed) != len(bookmarks) {
+		t.Fatalf("got %d bookmarks back, want %d", len(parsed), len(bookmarks))
+	}
+
+	for i, n := range parsed {
+		got, want := n.ToBookmark(), bookmarks[i]
+		if got.URL != want.URL || got.Title != want.Title || got.Description != want.Description ||
+			got.Tags != want.Tags || got.Shortcut != want.Shortcut || got.CreatedAt != want.CreatedAt ||
+			got.UpdatedAt != want.UpdatedAt || got.IsPrivate != want.IsPrivate || got.ToRead() != want.ToRead() {
+			t.Errorf("bookmark %d came back as\n%+v\nwant\n%+v", i, got, want)
+		}
+	}
+}
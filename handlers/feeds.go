@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
+)
+
+type key int
+
+const FEED_KEY key = 0
+
+// FEED_CONTENT_TYPES lists the feed formats a listing can be requested in
+// by adding feed.<format> to its path, e.g. /tags/go/feed.atom
+var FEED_CONTENT_TYPES = map[string]string{
+	"atom": "application/atom+xml; charset=utf-8",
+	"rss":  "application/rss+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
+}
+
+// withFeed strips feed.<format> from the end of the path and remembers the
+// format so that renderBookmarks writes a feed instead of a page
+func withFeed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, format := splitFeedPath(r.URL.Path)
+		if format == "" {
+			next(w, r)
+			return
+		}
+
+		r2 := r.WithContext(context.WithValue(r.Context(), FEED_KEY, format))
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = dir
+		r2.URL.RawPath = ""
+		next(w, r2)
+	}
+}
+
+// splitFeedPath returns the listing a feed path is for and the format
+// asked for, which is empty if p isn't a feed
+func splitFeedPath(p string) (dir, format string) {
+	dir, file := path.Split(p)
+	name, format, _ := strings.Cut(file, ".")
+	if name != "feed" || FEED_CONTENT_TYPES[format] == "" {
+		return p, ""
+	}
+	return dir, format
+}
+
+// feedFormat returns the feed format requested or "" for regular pages
+func feedFormat(r *http.Request) string {
+	format, _ := r.Context().Value(FEED_KEY).(string)
+	return format
+}
+
+// renderBookmarks writes a listing either as a page or, if one was asked
+// for, a feed of its first page
+func renderBookmarks(w http.ResponseWriter, r *http.Request, d lib.TemplateData, bookmarks []data.Bookmark, pg data.Pagination) {
+	if format := feedFormat(r); format != "" {
+		writeFeed(w, r, format, d.Title, bookmarks)
+		return
+	}
+
+	d.Data = newWithBookmarks(r, bookmarks, pg)
+	d.Feed = feedURL(r, "atom")
+	lib.RenderTemplate(w, r, "index.html", d)
+}
+
+// libraryPath returns the path of the current listing as seen by the
+// browser, i.e. including the /u/<name> prefix
+func libraryPath(r *http.Request) string {
+	p := r.URL.Path
+	if o := lib.GetOwner(r.Context()); o != nil {
+		p = o.BasePath() + p
+	}
+	return p
+}
+
+// listingURL returns the path of the page a feed was made from. Only the
+// search query is kept since feeds always start at the newest bookmark.
+func listingURL(r *http.Request) string {
+	return listingURLWithPath(r, libraryPath(r))
+}
+
+// feedURL returns the path of the feed for the current listing. Feeds only
+// ever have public bookmarks, so people looking at their own library get
+// the one under /u/<name>/ that has just theirs.
+func feedURL(r *http.Request, format string) string {
+	p := libraryPath(r)
+	if s := lib.GetSession(r.Context()); s != nil && lib.GetOwner(r.Context()) == nil {
+		p = "/u/" + s.UserName + p
+	}
+	return listingURLWithPath(r, strings.TrimSuffix(p, "/")+"/feed."+format)
+}
+
+func listingURLWithPath(r *http.Request, p string) string {
+	u := url.URL{Path: p}
+	if q := r.URL.Query().Get("q"); q != "" {
+		u.RawQuery = url.Values{"q": {q}}.Encode()
+	}
+	return u.String()
+}
+
+func absoluteURL(r *http.Request, ref string) string {
+	scheme := "http"
+	if lib.IsSecure(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + ref
+}
+
+// feedAuthor is who the feed belongs to: the owner of the library or, for
+// everyone's public bookmarks, the site itself
+func feedAuthor(r *http.Request) string {
+	if o := lib.GetOwner(r.Context()); o != nil {
+		return o.UserName
+	}
+	return r.Host
+}
+
+// feedEntryID is a tag URI (RFC 4151) that stays the same for a bookmark
+// no matter which feed it shows up in
+func feedEntryID(r *http.Request, b data.Bookmark) string {
+	host := r.Host
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	return fmt.Sprintf("tag:%s,%s:bookmark/%d", host, b.TimeCreated().UTC().Format("2006-01-02"), b.ID)
+}
+
+func feedUpdated(bookmarks []data.Bookmark) time.Time {
+	var ts int64
+	for _, b := range bookmarks {
+		if b.UpdatedAt > ts {
+			ts = b.UpdatedAt
+		}
+	}
+
+	if ts == 0 {
+		return time.Now().UTC()
+	}
+	return time.Unix(ts, 0).UTC()
+}
+
+func writeFeed(w http.ResponseWriter, r *http.Request, format string, title string, bookmarks []data.Bookmark) {
+	var body []byte
+	var err error
+
+	switch format {
+	case "atom":
+		body, err = xml.MarshalIndent(newAtomFeed(r, title, bookmarks), "", "  ")
+	case "rss":
+		body, err = xml.MarshalIndent(newRSSFeed(r, title, bookmarks), "", "  ")
+	case "json":
+		body, err = json.MarshalIndent(newJSONFeed(r, title, bookmarks), "", "  ")
+	}
+
+	if err != nil {
+		fmt.Printf("writeFeed(%s): %v\n", format, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", FEED_CONTENT_TYPES[format])
+	if format != "json" {
+		w.Write([]byte(xml.Header))
+	}
+	w.Write(body)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary,omitempty"`
+}
+
+func newAtomFeed(r *http.Request, title string, bookmarks []data.Bookmark) atomFeed {
+	self := absoluteURL(r, feedURL(r, "atom"))
+	f := atomFeed{
+		Title:   title,
+		ID:      self,
+		Updated: feedUpdated(bookmarks).Format(time.RFC3339),
+		Author:  atomPerson{Name: feedAuthor(r)},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: absoluteURL(r, listingURL(r))},
+		},
+		Entries: []atomEntry{},
+	}
+
+	for _, b := range bookmarks {
+		e := atomEntry{
+			Title:     b.Title,
+			ID:        feedEntryID(r, b),
+			Link:      atomLink{Rel: "alternate", Href: b.URL},
+			Published: b.TimeCreated().UTC().Format(time.RFC3339),
+			Updated:   b.TimeUpdated().UTC().Format(time.RFC3339),
+			Summary:   b.Description,
+		}
+
+		for _, a := range b.ParseAuthors() {
+			e.Authors = append(e.Authors, atomPerson{Name: a})
+		}
+
+		for _, t := range b.ParseTags() {
+			e.Categories = append(e.Categories, atomCategory{Term: t})
+		}
+
+		f.Entries = append(f.Entries, e)
+	}
+
+	return f
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description,omitempty"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creators    []string `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+}
+
+func newRSSFeed(r *http.Request, title string, bookmarks []data.Bookmark) rssFeed {
+	f := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          absoluteURL(r, listingURL(r)),
+			Description:   fmt.Sprintf("Bookmarks saved by %s", feedAuthor(r)),
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: absoluteURL(r, feedURL(r, "rss"))},
+			LastBuildDate: feedUpdated(bookmarks).Format(time.RFC1123Z),
+		},
+	}
+
+	for _, b := range bookmarks {
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       b.Title,
+			Link:        b.URL,
+			Description: b.Description,
+			GUID:        rssGUID{Value: feedEntryID(r, b)},
+			PubDate:     b.TimeCreated().UTC().Format(time.RFC1123Z),
+			Creators:    b.ParseAuthors(),
+			Categories:  b.ParseTags(),
+		})
+	}
+
+	return f
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+func newJSONFeed(r *http.Request, title string, bookmarks []data.Bookmark) jsonFeed {
+	f := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       title,
+		HomePageURL: absoluteURL(r, listingURL(r)),
+		FeedURL:     absoluteURL(r, feedURL(r, "json")),
+		Authors:     []jsonFeedAuthor{{Name: feedAuthor(r)}},
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, b := range bookmarks {
+		item := jsonFeedItem{
+			ID:            feedEntryID(r, b),
+			URL:           b.URL,
+			Title:         b.Title,
+			ContentText:   b.Description,
+			DatePublished: b.TimeCreated().UTC().Format(time.RFC3339),
+			DateModified:  b.TimeUpdated().UTC().Format(time.RFC3339),
+			Tags:          b.ParseTags(),
+		}
+
+		for _, a := range b.ParseAuthors() {
+			item.Authors = append(item.Authors, jsonFeedAuthor{Name: a})
+		}
+
+		f.Items = append(f.Items, item)
+	}
+
+	return f
+}
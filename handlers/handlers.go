@@ -1,28 +1,118 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/jobs"
 	"github.com/valueof/bland/lib"
 )
 
 func RegisterHandlers(r *http.ServeMux) {
-	r.HandleFunc("/", index)
-	r.HandleFunc("/unread/", unread)
-	r.HandleFunc("/shortcuts/", shortcuts)
-	r.HandleFunc("/tags/", tags)
-	r.HandleFunc("/authors/", authors)
+	registerLibraryHandlers(r)
+
+	library := http.NewServeMux()
+	registerLibraryHandlers(library)
+	r.HandleFunc("/u/", userLibrary(library))
+
+	r.HandleFunc("/trash/", trash)
+	r.HandleFunc("/broken/", broken)
 	r.HandleFunc("/add/", addURL)
 	r.HandleFunc("/edit/", editURL)
+	r.HandleFunc("/history/", history)
 
 	registerApiHandlers(r)
+	registerExportHandlers(r)
+	registerSettingsHandlers(r)
+	registerIconHandlers(r)
+	registerArchiveHandlers(r)
+	registerReaderHandlers(r)
+	registerAdminHandlers(r)
+}
+
+// registerLibraryHandlers adds the pages for browsing a library. They're
+// served at the root for the logged in user and under /u/<name>/ for
+// everyone's public bookmarks. Every listing is also available as a feed,
+// see withFeed.
+func registerLibraryHandlers(r *http.ServeMux) {
+	r.HandleFunc("/", withFeed(index))
+	r.HandleFunc("/unread/", withFeed(unread))
+	r.HandleFunc("/shortcuts/", withFeed(shortcuts))
+	r.HandleFunc("/tags/", withFeed(tags))
+	r.HandleFunc("/authors/", withFeed(authors))
+	r.HandleFunc("/search", search)
+	r.HandleFunc("/search/", withFeed(search))
+}
+
+// userLibrary looks up the user named in /u/<name>/... and hands the rest
+// of the path over to library with that user as the owner. People looking
+// at their own library are sent to the root where they can edit it.
+func userLibrary(library *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/u/"), "/")
+		rest = "/" + rest
+
+		user, err := data.FetchUserByName(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		// Feeds stay here since they only have public bookmarks, which
+		// is what the owner wants to share
+		_, feed := splitFeedPath(rest)
+		if s := lib.GetSession(r.Context()); s != nil && s.UserID == user.ID && feed == "" {
+			u := *r.URL
+			u.Path = rest
+			http.Redirect(w, r, u.String(), http.StatusSeeOther)
+			return
+		}
+
+		if strings.HasSuffix(strings.TrimSuffix(rest, "/"), "/manage") {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		owner := lib.Owner{UserID: user.ID, UserName: user.Name}
+		r2 := r.WithContext(lib.WithOwner(r.Context(), owner))
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+
+		// ServeMux would redirect /tags to /tags/ without our prefix so
+		// take care of that here
+		if _, pattern := library.Handler(r2); pattern == rest+"/" {
+			u := *r.URL
+			u.Path = owner.BasePath() + pattern
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+
+		library.ServeHTTP(w, r2)
+	}
 }
 
 type withBookmarks struct {
 	Bookmarks *[]data.Bookmark
+	Next      string
+	Prev      string
+}
+
+func newWithBookmarks(r *http.Request, bookmarks []data.Bookmark, pg data.Pagination) withBookmarks {
+	return withBookmarks{
+		Bookmarks: &bookmarks,
+		Next:      pageURL(r, "before", pg.Next),
+		Prev:      pageURL(r, "after", pg.Prev),
+	}
 }
 
 type withTags struct {
@@ -31,7 +121,7 @@ type withTags struct {
 
 func index(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
-		if url, ok := data.GetShortcutURL(strings.Trim(r.URL.Path, "/")); ok {
+		if url, ok := data.GetShortcutURL(scopeFor(r), strings.Trim(r.URL.Path, "/")); ok {
 			http.Redirect(w, r, url, http.StatusSeeOther)
 			return
 		}
@@ -41,59 +131,115 @@ func index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bookmarks, err := data.FetchAllBookmarks()
+	p, err := parsePageFromRequest(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintln(w, err)
 		return
 	}
 
-	lib.RenderTemplate(w, r, "index.html", lib.TemplateData{
-		Title: "bland: all",
-		Data: withBookmarks{
-			Bookmarks: &bookmarks,
-		},
-	})
+	bookmarks, pg, err := data.FetchAllBookmarks(scopeFor(r), p)
+	if err != nil {
+		w.WriteHeader(fetchErrorStatus(err))
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	renderBookmarks(w, r, lib.TemplateData{Title: "bland: all"}, bookmarks, pg)
 }
 
 func unread(w http.ResponseWriter, r *http.Request) {
-	bookmarks, err := data.FetchUnreadBookmarks()
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	bookmarks, pg, err := data.FetchUnreadBookmarks(scopeFor(r), p)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(fetchErrorStatus(err))
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	renderBookmarks(w, r, lib.TemplateData{Title: "bland: unread"}, bookmarks, pg)
+}
+
+func shortcuts(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	bookmarks, pg, err := data.FetchShortcuts(scopeFor(r), p)
+
+	if err != nil {
+		w.WriteHeader(fetchErrorStatus(err))
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	renderBookmarks(w, r, lib.TemplateData{Title: "bland: shortcuts"}, bookmarks, pg)
+}
+
+func trash(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	bookmarks, pg, err := data.FetchDeletedBookmarks(scopeFor(r), p)
+	if err != nil {
+		w.WriteHeader(fetchErrorStatus(err))
 		fmt.Fprintln(w, err)
 		return
 	}
 
 	lib.RenderTemplate(w, r, "index.html", lib.TemplateData{
-		Title: "bland: unread",
-		Data: withBookmarks{
-			Bookmarks: &bookmarks,
-		},
+		Title: "bland: trash",
+		Data:  newWithBookmarks(r, bookmarks, pg),
 	})
 }
 
-func shortcuts(w http.ResponseWriter, r *http.Request) {
-	bookmarks, err := data.FetchShortcuts()
+// broken lists bookmarks whose links were found to be broken the last time
+// they were checked
+func broken(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
 
+	bookmarks, pg, err := data.FetchBrokenBookmarks(scopeFor(r), p)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(fetchErrorStatus(err))
 		fmt.Fprintln(w, err)
 		return
 	}
 
 	lib.RenderTemplate(w, r, "index.html", lib.TemplateData{
-		Title: "bland: shortcuts",
-		Data: withBookmarks{
-			Bookmarks: &bookmarks,
-		},
+		Title: "bland: broken links",
+		Data:  newWithBookmarks(r, bookmarks, pg),
 	})
 }
 
 func tags(w http.ResponseWriter, r *http.Request) {
 	tagName := strings.TrimPrefix(r.URL.Path, "/tags/")
 	if tagName == "" {
-		tags, err := data.FetchAllTags()
+		if feedFormat(r) != "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		tags, err := data.FetchAllTags(scopeFor(r))
 		if err != nil {
 			fmt.Printf("fmt.FetchAllTags: %v\n", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -111,25 +257,44 @@ func tags(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tagName = strings.Trim(tagName, "/")
-	bookmarks, err := data.FetchBookmarksByTag(tagName)
+	if strings.HasSuffix(tagName, "/manage") {
+		if feedFormat(r) != "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		manageTag(w, r, strings.TrimSuffix(tagName, "/manage"), "")
+		return
+	}
+
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	bookmarks, pg, err := data.FetchBookmarksByTag(scopeFor(r), tagName, p)
 	if err != nil {
 		fmt.Printf("data.FetchBookmarksByTag: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(fetchErrorStatus(err))
 		return
 	}
 
-	lib.RenderTemplate(w, r, "index.html", lib.TemplateData{
-		Title: "bland: " + tagName,
-		Data: withBookmarks{
-			Bookmarks: &bookmarks,
-		},
-	})
+	renderBookmarks(w, r, lib.TemplateData{Title: "bland: " + tagName}, bookmarks, pg)
 }
 
 func authors(w http.ResponseWriter, r *http.Request) {
 	tagName := strings.TrimPrefix(r.URL.Path, "/authors/")
 	if tagName == "" {
-		tags, err := data.FetchAllAuthors()
+		if feedFormat(r) != "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		tags, err := data.FetchAllAuthors(scopeFor(r))
 		if err != nil {
 			fmt.Printf("fmt.FetchAllAuthors: %v\n", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -152,27 +317,165 @@ func authors(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tagName = strings.Trim(tagName, "/")
-	bookmarks, err := data.FetchBookmarksByTag("by:" + tagName)
+	if strings.HasSuffix(tagName, "/manage") {
+		if feedFormat(r) != "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "404 Not Found")
+			return
+		}
+
+		manageTag(w, r, strings.TrimSuffix(tagName, "/manage"), "by:")
+		return
+	}
+
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	bookmarks, pg, err := data.FetchBookmarksByTag(scopeFor(r), "by:"+tagName, p)
 	if err != nil {
 		fmt.Printf("data.FetchBookmarksByTag: %v\n", err)
+		w.WriteHeader(fetchErrorStatus(err))
+		return
+	}
+
+	renderBookmarks(w, r, lib.TemplateData{Title: "bland: by " + tagName}, bookmarks, pg)
+}
+
+func search(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSuffix(r.URL.Path, "/") != "/search" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	query, err := data.ParseQuery(q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	bookmarks, pg, err := data.SearchBookmarks(scopeFor(r), query, p)
+	if err != nil {
+		fmt.Printf("data.SearchBookmarks: %v\n", err)
+		w.WriteHeader(fetchErrorStatus(err))
+		return
+	}
+
+	renderBookmarks(w, r, lib.TemplateData{Title: "bland: search", Query: q}, bookmarks, pg)
+}
+
+type withForm struct {
+	*data.Bookmark
+	Duplicate *data.Bookmark
+}
+
+type withTagForm struct {
+	Name     string
+	IsAuthor bool
+	Error    string
+}
+
+// manageTag renders and handles the rename/merge/delete form for a tag.
+// Authors are tags prefixed with "by:" but the prefix is never shown or
+// typed in the form.
+func manageTag(w http.ResponseWriter, r *http.Request, name, prefix string) {
+	base := "/tags/"
+	if prefix == "by:" {
+		base = "/authors/"
+	}
+
+	render := func(status int, msg string) {
+		w.WriteHeader(status)
+		lib.RenderTemplate(w, r, "manage.html", lib.TemplateData{
+			Title: "bland: manage " + name,
+			Data: withTagForm{
+				Name:     name,
+				IsAuthor: prefix == "by:",
+				Error:    msg,
+			},
+		})
+	}
+
+	if r.Method == "GET" {
+		render(http.StatusOK, "")
+		return
+	}
+
+	if r.Method != "POST" {
+		fmt.Printf("wrong request method: expected GET/POST, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	action := r.FormValue("action")
+	target := strings.TrimPrefix(strings.TrimSpace(r.FormValue("target")), prefix)
+	if action != "delete" && !data.ValidTagName(prefix+target) {
+		render(http.StatusBadRequest, "names can't be empty or contain spaces")
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		fmt.Printf("data.BeginTx: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	lib.RenderTemplate(w, r, "index.html", lib.TemplateData{
-		Title: "bland: by " + tagName,
-		Data: withBookmarks{
-			Bookmarks: &bookmarks,
-		},
-	})
+	redirect := base + target
+	switch action {
+	case "rename":
+		err = tx.RenameTag(prefix+name, prefix+target)
+	case "merge":
+		err = tx.MergeTags(prefix+name, prefix+target)
+	case "delete":
+		err = tx.DeleteTag(prefix + name)
+		redirect = base
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Printf("manageTag: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Printf("tx.Commit: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
 }
 
 func addURL(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		lib.RenderTemplate(w, r, "form.html", lib.TemplateData{
 			Title: "bland: add url",
-			Data: &data.Bookmark{
-				ReadAt: 1, // For the 'add url' form, the “to read” checkbox should be unchecked by default
+			Data: withForm{
+				Bookmark: &data.Bookmark{
+					ReadAt: 1, // For the 'add url' form, the “to read” checkbox should be unchecked by default
+				},
 			},
 		})
 		return
@@ -187,24 +490,65 @@ func addURL(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		tx, err := data.BeginTx(r.Context())
-		if err != nil {
+		b := data.BookmarkFromRequest(r)
+		existing, err := data.FetchBookmarkByURL(scopeFor(r), b.URL)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		b := data.BookmarkFromRequest(r)
-		if _, err := tx.AddBookmark(*b); err != nil {
-			fmt.Println(err)
-			tx.Rollback()
+		// The form is re-rendered with a warning when the URL has been
+		// saved before. The user can then pick between merging the two
+		// bookmarks (duplicate=merge) or saving anyway (duplicate=keep).
+		onDuplicate := r.FormValue("duplicate")
+		if existing != nil && onDuplicate == "" {
+			lib.RenderTemplate(w, r, "form.html", lib.TemplateData{
+				Title: "bland: add url",
+				Data: withForm{
+					Bookmark:  b,
+					Duplicate: existing,
+				},
+			})
+			return
+		}
+
+		tx, err := data.BeginTx(r.Context())
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		if existing != nil && onDuplicate == "merge" {
+			if err := tx.MergeBookmark(existing.ID, *b); err != nil {
+				fmt.Println(err)
+				tx.Rollback()
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		} else {
+			id, err := tx.AddBookmark(*b)
+			if err == nil {
+				err = tx.QueueBookmarkJobs(id)
+			}
+
+			if err != nil {
+				fmt.Println(err)
+				tx.Rollback()
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
 		if err := tx.Commit(); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		jobs.Wake()
+
+		if existing != nil && onDuplicate == "merge" {
+			http.Redirect(w, r, fmt.Sprintf("/edit/%d", existing.ID), http.StatusSeeOther)
+			return
+		}
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -223,7 +567,7 @@ func editURL(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		b, err := data.FetchBookmarkByID(id)
+		b, err := data.FetchBookmarkByID(scopeFor(r), id)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -231,7 +575,7 @@ func editURL(w http.ResponseWriter, r *http.Request) {
 
 		lib.RenderTemplate(w, r, "form.html", lib.TemplateData{
 			Title: "bland: edit url",
-			Data:  b,
+			Data:  withForm{Bookmark: b},
 		})
 		return
 	}
@@ -267,11 +611,117 @@ func editURL(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		jobs.Wake()
+
+		// Start the listing at the edited bookmark since it's not
+		// necessarily on the first page anymore
+		u := fmt.Sprintf("/#bookmark-%d", b.ID)
+		if saved, err := data.FetchBookmarkByID(scopeFor(r), b.ID); err == nil {
+			c := &data.Cursor{CreatedAt: saved.CreatedAt, ID: saved.ID + 1}
+			u = fmt.Sprintf("/?before=%s#bookmark-%d", c, b.ID)
+		}
 
-		http.Redirect(w, r, fmt.Sprintf("/#bookmark-%d", b.ID), http.StatusSeeOther)
+		http.Redirect(w, r, u, http.StatusSeeOther)
 		return
 	}
 
 	fmt.Printf("wrong request method: expected GET/POST, got %s\n", r.Method)
 	w.WriteHeader(http.StatusBadRequest)
 }
+
+type revisionWithChanges struct {
+	data.Revision
+	Changes []data.FieldChange
+	Current bool
+}
+
+type withHistory struct {
+	Bookmark  *data.Bookmark
+	Revisions []revisionWithChanges
+}
+
+func history(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDFromPath(r, "/history/")
+	if err != nil {
+		fmt.Printf("history: %v\n", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "POST" {
+		rev, err := strconv.ParseInt(r.FormValue("revision"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		tx, err := data.BeginTx(r.Context())
+		if err != nil {
+			fmt.Printf("data.BeginTx: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.RestoreRevision(id, rev); err != nil {
+			tx.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			fmt.Printf("tx.RestoreRevision: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			fmt.Printf("tx.Commit: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		jobs.Wake()
+
+		http.Redirect(w, r, fmt.Sprintf("/history/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != "GET" {
+		fmt.Printf("wrong request method: expected GET/POST, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b, err := data.FetchBookmarkByID(scopeFor(r), id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	revisions, err := data.FetchRevisions(id)
+	if err != nil {
+		fmt.Printf("data.FetchRevisions: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Revisions are sorted newest first so each one is compared with
+	// the one that follows it
+	d := withHistory{Bookmark: b}
+	for i, rev := range revisions {
+		var prev *data.Revision
+		if i+1 < len(revisions) {
+			prev = &revisions[i+1]
+		}
+
+		d.Revisions = append(d.Revisions, revisionWithChanges{
+			Revision: rev,
+			Changes:  rev.Diff(prev),
+			Current:  i == 0,
+		})
+	}
+
+	lib.RenderTemplate(w, r, "history.html", lib.TemplateData{
+		Title: "bland: history",
+		Data:  d,
+	})
+}
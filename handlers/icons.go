@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
+)
+
+// HOST_RE matches the hosts icons can be requested for: a name or IPv4
+// address, optionally followed by a port
+var HOST_RE *regexp.Regexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?(:[0-9]+)?$`)
+
+// BLANK_ICON is a transparent 1x1 GIF served for hosts without an icon so
+// that listings don't show broken images
+var BLANK_ICON = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x21, 0xf9, 0x04, 0x01, 0x00,
+	0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00,
+	0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+func registerIconHandlers(r *http.ServeMux) {
+	r.HandleFunc("/icons/", icon)
+}
+
+// icon serves the cached favicon of the host in /icons/<host>. Icons are
+// only served for hosts the visitor can see a bookmark of, otherwise they
+// would tell anyone which sites were bookmarked privately.
+func icon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		fmt.Printf("wrong request method: expected GET, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	host := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/icons/"))
+	if !HOST_RE.MatchString(host) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+	visible, err := iconIsVisible(r, host)
+	if err != nil {
+		fmt.Printf("data.HostIsVisible: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if visible {
+		f, err := data.FetchFavicon(host)
+		if err != nil && err != sql.ErrNoRows {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if f != nil && len(f.Data) > 0 {
+			w.Header().Set("Content-Type", f.ContentType)
+			w.Header().Set("Cache-Control", "private, max-age=86400")
+			w.Write(f.Data)
+			return
+		}
+	}
+
+	// The refresher may find an icon later on, so this isn't cached for
+	// long
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(BLANK_ICON)
+}
+
+// iconIsVisible reports whether host has a public bookmark or one that
+// belongs to the logged in user
+func iconIsVisible(r *http.Request, host string) (bool, error) {
+	ok, err := data.HostIsVisible(data.Scope{}, host)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	if s := lib.GetSession(r.Context()); s != nil {
+		return data.HostIsVisible(data.OwnScope(s.UserID), host)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/metadata"
+)
+
+// OPENAPI_DOCUMENT describes API_ROUTES for clients, served at
+// /api/openapi.json
+//
+//go:embed openapi.json
+var OPENAPI_DOCUMENT []byte
+
+func openAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OPENAPI_DOCUMENT)
+}
+
+type openAPIOperation struct {
+	Security []map[string][]string `json:"security"`
+}
+
+type openAPISpec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// OPENAPI_SCHEMAS maps the schemas in openapi.json to values of the types
+// they describe, the JSON keys of both have to match
+var OPENAPI_SCHEMAS = map[string]any{
+	"Bookmark":      data.Bookmark{},
+	"BookmarkInput": apiBookmarkInput{},
+	"BookmarkList":  apiBookmarkList{Pagination: data.Pagination{Next: &data.Cursor{}, Prev: &data.Cursor{}}},
+	"Tag":           data.Tag{},
+	"TagList":       apiTagList{},
+	"Metadata":      metadata.Metadata{},
+	"Error":         apiError{},
+}
+
+var HTTP_METHODS = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// checkOpenAPI makes sure doc has an operation for every route, requiring
+// the same token scope, and nothing else, and that its schemas have the
+// same properties as the types in OPENAPI_SCHEMAS
+func checkOpenAPI(doc []byte, routes []apiRoute) error {
+	spec := openAPISpec{}
+	if err := json.Unmarshal(doc, &spec); err != nil {
+		return err
+	}
+
+	problems := []string{}
+	seen := map[string]bool{}
+
+	for _, route := range routes {
+		name := route.Method + " " + route.Path
+		seen[name] = true
+
+		raw, ok := spec.Paths[route.Path][strings.ToLower(route.Method)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+			continue
+		}
+
+		op := openAPIOperation{}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		scopes := []string{}
+		for _, req := range op.Security {
+			scopes = append(scopes, req["bearerAuth"]...)
+		}
+
+		if route.Scope == "" && (op.Security == nil || len(scopes) > 0) {
+			problems = append(problems, fmt.Sprintf("%s should have an empty security list", name))
+		} else if route.Scope != "" && (len(scopes) != 1 || scopes[0] != route.Scope) {
+			problems = append(problems, fmt.Sprintf("%s should require the %s scope, not %v", name, route.Scope, scopes))
+		}
+	}
+
+	for path, item := range spec.Paths {
+		for _, method := range HTTP_METHODS {
+			name := strings.ToUpper(method) + " " + path
+			if _, ok := item[method]; ok && !seen[name] {
+				problems = append(problems, fmt.Sprintf("%s has no handler", name))
+			}
+		}
+	}
+
+	for name, v := range OPENAPI_SCHEMAS {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("schema %s is missing", name))
+			continue
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return err
+		}
+
+		for f := range fields {
+			if _, ok := schema.Properties[f]; !ok {
+				problems = append(problems, fmt.Sprintf("schema %s is missing %s", name, f))
+			}
+		}
+
+		for f := range schema.Properties {
+			if _, ok := fields[f]; !ok {
+				problems = append(problems, fmt.Sprintf("schema %s has unknown property %s", name, f))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	if err := checkOpenAPI(OPENAPI_DOCUMENT, API_ROUTES); err != nil {
+		t.Fatalf("handlers/openapi.json is out of sync with API_ROUTES: %v", err)
+	}
+}
+
+func TestCheckOpenAPI(t *testing.T) {
+	routes := []apiRoute{
+		{"GET", "/api/openapi.json", "", openAPIDocument},
+		{"GET", "/api/bookmarks/{id}", "read", apiGetBookmark},
+	}
+
+	tests := []struct {
+		name  string
+		paths string
+		want  string
+	}{
+		{
+			"missing route",
+			`"/api/openapi.json": {"get": {"security": []}}`,
+			"GET /api/bookmarks/{id} is missing",
+		},
+		{
+			"wrong scope",
+			`"/api/openapi.json": {"get": {"security": []}},
+			"/api/bookmarks/{id}": {"get": {"security": [{"bearerAuth": ["write"]}]}}`,
+			"should require the read scope",
+		},
+		{
+			"public route without security",
+			`"/api/openapi.json": {"get": {}},
+			"/api/bookmarks/{id}": {"get": {"security": [{"bearerAuth": ["read"]}]}}`,
+			"should have an empty security list",
+		},
+		{
+			"unknown route",
+			`"/api/openapi.json": {"get": {"security": []}},
+			"/api/bookmarks/{id}": {"get": {"security": [{"bearerAuth": ["read"]}]}, "post": {}}`,
+			"POST /api/bookmarks/{id} has no handler",
+		},
+	}
+
+	for _, tt := range tests {
+		err := checkOpenAPI([]byte(`{"paths": {`+tt.paths+`}}`), routes)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
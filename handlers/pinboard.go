@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/jobs"
+	"github.com/valueof/bland/lib"
+	s "github.com/valueof/bland/setup"
+)
+
+// RegisterPinboardHandlers exposes a subset of the Pinboard v1 API under
+// /v1/ so existing clients (browser extensions, shortcuts, scripts) keep
+// working. Every request must carry ?auth_token=user:token where token is
+// one of the user's API tokens, which also decides what the request may
+// do: reading needs the read scope and changing anything needs write.
+func RegisterPinboardHandlers(r *http.ServeMux) {
+	r.Handle("/v1/posts/add", pinboardAuth(data.SCOPE_WRITE, pinboardAddPost))
+	r.Handle("/v1/posts/delete", pinboardAuth(data.SCOPE_WRITE, pinboardDeletePost))
+	r.Handle("/v1/posts/get", pinboardAuth(data.SCOPE_READ, pinboardGetPosts))
+	r.Handle("/v1/posts/recent", pinboardAuth(data.SCOPE_READ, pinboardRecentPosts))
+	r.Handle("/v1/posts/all", pinboardAuth(data.SCOPE_READ, pinboardAllPosts))
+	r.Handle("/v1/posts/update", pinboardAuth(data.SCOPE_READ, pinboardUpdate))
+	r.Handle("/v1/tags/get", pinboardAuth(data.SCOPE_READ, pinboardGetTags))
+	r.Handle("/v1/tags/rename", pinboardAuth(data.SCOPE_WRITE, pinboardRenameTag))
+	r.Handle("/v1/tags/delete", pinboardAuth(data.SCOPE_WRITE, pinboardDeleteTag))
+}
+
+// pinboardAuth checks the auth_token of the request against the API tokens
+// of the user it names
+func pinboardAuth(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, secret, _ := strings.Cut(r.FormValue("auth_token"), ":")
+		t, user, err := data.AuthenticateAPIToken(secret)
+		if err != nil || user.Name != name {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, "401 Forbidden")
+			return
+		}
+
+		if !data.ScopesAllow(t.Scopes, scope) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "403 Forbidden: requires the %s scope\n", scope)
+			return
+		}
+
+		s := lib.Session{UserID: user.ID, UserName: user.Name, TokenID: t.ID, Scopes: t.Scopes}
+		next(w, r.WithContext(lib.WithSession(r.Context(), s)))
+	})
+}
+
+// pinboardUser returns the session pinboardAuth set up for the request
+func pinboardUser(r *http.Request) (name string, scope data.Scope) {
+	s := lib.GetSession(r.Context())
+	return s.UserName, data.OwnScope(s.UserID)
+}
+
+type pinboardPosts struct {
+	XMLName xml.Name           `json:"-" xml:"posts"`
+	User    string             `json:"user" xml:"user,attr"`
+	Date    string             `json:"date,omitempty" xml:"dt,attr,omitempty"`
+	Posts   []s.PinboardSchema `json:"posts" xml:"post"`
+}
+
+type pinboardResult struct {
+	XMLName xml.Name `json:"-" xml:"result"`
+	Code    string   `json:"result_code" xml:"code,attr"`
+}
+
+type pinboardUpdateTime struct {
+	XMLName xml.Name `json:"-" xml:"update"`
+	Time    string   `json:"update_time" xml:"time,attr"`
+}
+
+type pinboardTag struct {
+	Tag   string `xml:"tag,attr"`
+	Count int64  `xml:"count,attr"`
+}
+
+type pinboardTags struct {
+	XMLName xml.Name      `xml:"tags"`
+	Tags    []pinboardTag `xml:"tag"`
+}
+
+// writePinboard encodes v as XML unless the client asked for
+// ?format=json. jsonValue is used instead of v for JSON responses when the
+// two formats don't share the same shape.
+func writePinboard(w http.ResponseWriter, r *http.Request, v any, jsonValue any) {
+	if r.FormValue("format") == "json" {
+		if jsonValue == nil {
+			jsonValue = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jsonValue)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("writePinboard: %v\n", err)
+	}
+}
+
+func writePinboardResult(w http.ResponseWriter, r *http.Request, code string) {
+	writePinboard(w, r, pinboardResult{Code: code}, nil)
+}
+
+func toPinboardPosts(bookmarks []data.Bookmark) []s.PinboardSchema {
+	posts := []s.PinboardSchema{}
+	for _, b := range bookmarks {
+		posts = append(posts, s.PinboardFromBookmark(b))
+	}
+	return posts
+}
+
+// pinboardQuery builds a query out of the tag parameter, which holds up to
+// three space separated tags that must all match
+func pinboardQuery(r *http.Request) *data.Query {
+	q := &data.Query{}
+	for _, t := range strings.Fields(r.FormValue("tag")) {
+		q.Terms = append(q.Terms, &data.TagTerm{Name: t})
+	}
+	return q
+}
+
+// fetchPinboard returns up to limit bookmarks (all of them if limit is 0)
+// in the scope matching the query, newest first
+func fetchPinboard(scope data.Scope, q *data.Query, limit int) (bookmarks []data.Bookmark, err error) {
+	p := data.Page{Limit: data.MAX_PAGE_SIZE}
+	if limit > 0 && limit < data.MAX_PAGE_SIZE {
+		p.Limit = limit
+	}
+
+	for {
+		var page []data.Bookmark
+		var pg data.Pagination
+		if q.IsEmpty() {
+			page, pg, err = data.FetchAllBookmarks(scope, p)
+		} else {
+			page, pg, err = data.SearchBookmarks(scope, q, p)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		bookmarks = append(bookmarks, page...)
+		if pg.Next == nil || (limit > 0 && len(bookmarks) >= limit) {
+			break
+		}
+		p.Before = pg.Next
+	}
+
+	if limit > 0 && len(bookmarks) > limit {
+		bookmarks = bookmarks[:limit]
+	}
+
+	return bookmarks, nil
+}
+
+func pinboardAddPost(w http.ResponseWriter, r *http.Request) {
+	post := s.PinboardSchema{
+		HREF:        strings.TrimSpace(r.FormValue("url")),
+		Description: r.FormValue("description"),
+		Extended:    r.FormValue("extended"),
+		Tags:        r.FormValue("tags"),
+		Time:        r.FormValue("dt"),
+		ToRead:      r.FormValue("toread"),
+		Shared:      r.FormValue("shared"),
+	}
+
+	if post.HREF == "" {
+		writePinboardResult(w, r, "missing url")
+		return
+	}
+
+	if post.Description == "" {
+		writePinboardResult(w, r, "missing description")
+		return
+	}
+
+	_, scope := pinboardUser(r)
+	b := post.ToBookmark()
+	existing, err := data.FetchBookmarkByURL(scope, b.URL)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if existing != nil && r.FormValue("replace") == "no" {
+		writePinboardResult(w, r, "item already exists")
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if existing != nil {
+		b.ID = existing.ID
+		b.Shortcut = existing.Shortcut
+		err = tx.UpdateBookmark(b)
+	} else {
+		var id int64
+		if id, err = tx.AddBookmark(b); err == nil {
+			err = tx.QueueBookmarkJobs(id)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("pinboardAddPost: %v\n", err)
+		tx.Rollback()
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+	jobs.Wake()
+
+	writePinboardResult(w, r, "done")
+}
+
+func pinboardDeletePost(w http.ResponseWriter, r *http.Request) {
+	_, scope := pinboardUser(r)
+	b, err := data.FetchBookmarkByURL(scope, strings.TrimSpace(r.FormValue("url")))
+	if err != nil {
+		writePinboardResult(w, r, "item not found")
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.DeleteBookmark(b.ID); err != nil {
+		fmt.Printf("pinboardDeletePost: %v\n", err)
+		tx.Rollback()
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	writePinboardResult(w, r, "done")
+}
+
+// pinboardGetPosts returns posts for a single day (the most recent day
+// with posts unless ?dt= is given) or for a single ?url=
+func pinboardGetPosts(w http.ResponseWriter, r *http.Request) {
+	user, scope := pinboardUser(r)
+	res := pinboardPosts{User: user, Posts: []s.PinboardSchema{}}
+
+	if u := strings.TrimSpace(r.FormValue("url")); u != "" {
+		b, err := data.FetchBookmarkByURL(scope, u)
+		if err == nil {
+			res.Date = time.Unix(b.CreatedAt, 0).UTC().Format(s.PINBOARD_TIME)
+			res.Posts = toPinboardPosts([]data.Bookmark{*b})
+		}
+		writePinboard(w, r, res, nil)
+		return
+	}
+
+	day := time.Now().UTC()
+	if dt := r.FormValue("dt"); dt != "" {
+		d, err := time.Parse("2006-01-02", dt)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writePinboardResult(w, r, "invalid dt")
+			return
+		}
+		day = d
+	} else {
+		latest, _, err := data.FetchAllBookmarks(scope, data.Page{Limit: 1})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(latest) > 0 {
+			day = time.Unix(latest[0].CreatedAt, 0).UTC()
+		}
+	}
+
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	q := pinboardQuery(r)
+	q.Terms = append(q.Terms,
+		&data.DateTerm{Date: day},
+		&data.DateTerm{Date: day.AddDate(0, 0, 1), Before: true})
+
+	bookmarks, err := fetchPinboard(scope, q, 0)
+	if err != nil {
+		fmt.Printf("pinboardGetPosts: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Date = day.Format(s.PINBOARD_TIME)
+	res.Posts = toPinboardPosts(bookmarks)
+	writePinboard(w, r, res, nil)
+}
+
+func pinboardRecentPosts(w http.ResponseWriter, r *http.Request) {
+	count := 15
+	if c, err := strconv.Atoi(r.FormValue("count")); err == nil && c > 0 {
+		count = c
+	}
+	if count > 100 {
+		count = 100
+	}
+
+	user, scope := pinboardUser(r)
+	bookmarks, err := fetchPinboard(scope, pinboardQuery(r), count)
+	if err != nil {
+		fmt.Printf("pinboardRecentPosts: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := pinboardPosts{User: user, Posts: toPinboardPosts(bookmarks)}
+	if len(bookmarks) > 0 {
+		res.Date = time.Unix(bookmarks[0].CreatedAt, 0).UTC().Format(s.PINBOARD_TIME)
+	}
+
+	writePinboard(w, r, res, nil)
+}
+
+func pinboardAllPosts(w http.ResponseWriter, r *http.Request) {
+	q := pinboardQuery(r)
+	for _, param := range []string{"fromdt", "todt"} {
+		v := r.FormValue(param)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writePinboardResult(w, r, "invalid "+param)
+			return
+		}
+		q.Terms = append(q.Terms, &data.DateTerm{Date: t, Before: param == "todt"})
+	}
+
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	results, _ := strconv.Atoi(r.FormValue("results"))
+	if start < 0 {
+		start = 0
+	}
+
+	limit := 0
+	if results > 0 {
+		limit = start + results
+	}
+
+	user, scope := pinboardUser(r)
+	bookmarks, err := fetchPinboard(scope, q, limit)
+	if err != nil {
+		fmt.Printf("pinboardAllPosts: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if start > len(bookmarks) {
+		start = len(bookmarks)
+	}
+	posts := toPinboardPosts(bookmarks[start:])
+
+	// posts/all returns a bare array in JSON but a <posts> element in XML
+	writePinboard(w, r, pinboardPosts{User: user, Posts: posts}, posts)
+}
+
+func pinboardUpdate(w http.ResponseWriter, r *http.Request) {
+	_, scope := pinboardUser(r)
+	ts, err := data.LastUpdated(scope)
+	if err != nil {
+		fmt.Printf("pinboardUpdate: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := pinboardUpdateTime{Time: time.Unix(ts, 0).UTC().Format(s.PINBOARD_TIME)}
+	writePinboard(w, r, res, nil)
+}
+
+func pinboardGetTags(w http.ResponseWriter, r *http.Request) {
+	_, scope := pinboardUser(r)
+	tags, err := data.FetchAllTags(scope)
+	if err != nil {
+		fmt.Printf("data.FetchAllTags: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	authors, err := data.FetchAllAuthors(scope)
+	if err != nil {
+		fmt.Printf("data.FetchAllAuthors: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	counts := map[string]int64{}
+	res := pinboardTags{}
+	for _, t := range append(tags, authors...) {
+		counts[t.Name] = t.NumEntries
+		res.Tags = append(res.Tags, pinboardTag{Tag: t.Name, Count: t.NumEntries})
+	}
+
+	sort.Slice(res.Tags, func(i, j int) bool {
+		return res.Tags[i].Tag < res.Tags[j].Tag
+	})
+
+	writePinboard(w, r, res, counts)
+}
+
+func pinboardRenameTag(w http.ResponseWriter, r *http.Request) {
+	from := strings.TrimSpace(r.FormValue("old"))
+	to := strings.TrimSpace(r.FormValue("new"))
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.RenameTag(from, to); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			writePinboardResult(w, r, "tag not found")
+			return
+		}
+
+		fmt.Printf("pinboardRenameTag: %v\n", err)
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	writePinboardResult(w, r, "done")
+}
+
+func pinboardDeleteTag(w http.ResponseWriter, r *http.Request) {
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.DeleteTag(strings.TrimSpace(r.FormValue("tag"))); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			writePinboardResult(w, r, "tag not found")
+			return
+		}
+
+		fmt.Printf("pinboardDeleteTag: %v\n", err)
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writePinboardResult(w, r, "something went wrong")
+		return
+	}
+
+	writePinboardResult(w, r, "done")
+}
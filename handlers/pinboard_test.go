@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
+	"github.com/valueof/bland/setup"
+)
+
+// openTestDB connects the data package to a new database with every
+// migration applied
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	fp := filepath.Join(t.TempDir(), "bland.db")
+	db, err := sql.Open("sqlite3", fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations, err := setup.LoadMigrations(os.DirFS("../sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setup.Migrate(db, migrations, func(string, ...any) {}); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			t.Skip("needs -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+
+	if err := data.ConnectToDB(fp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addTestUser creates a user with one API token for each scope and returns
+// the tokens by scope
+func addTestUser(t *testing.T, name string) map[string]string {
+	t.Helper()
+
+	tx, err := data.BeginTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := tx.AddUser(name, "hunter222")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := lib.WithSession(context.Background(), lib.Session{UserID: id, UserName: name})
+	if tx, err = data.BeginTx(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]string{}
+	for _, scope := range data.SCOPES {
+		if tokens[scope], err = tx.AddAPIToken(scope, []string{scope}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	return tokens
+}
+
+func TestPinboardAPI(t *testing.T) {
+	openTestDB(t)
+	tokens := addTestUser(t, "anton")
+	addTestUser(t, "other")
+
+	mux := http.NewServeMux()
+	RegisterPinboardHandlers(mux)
+
+	call := func(path string, params url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path+"?"+params.Encode(), nil))
+		return w
+	}
+
+	write := "anton:" + tokens[data.SCOPE_WRITE]
+	read := "anton:" + tokens[data.SCOPE_READ]
+
+	for _, p := range []url.Values{
+		{"auth_token": {write}, "url": {"https://go.dev/blog/pipelines"}, "description": {"Pipelines"}, "extended": {"Fan-out & fan-in"},
+			"tags": {"go,concurrency"}, "dt": {"2022-10-17T09:30:00Z"}, "toread": {"yes"}},
+		{"auth_token": {write}, "url": {"https://example.com/"}, "description": {"Example"}, "tags": {"go"},
+			"dt": {"2022-10-16T12:00:00Z"}, "shared": {"no"}},
+	} {
+		if w := call("/v1/posts/add", p); w.Body.String() != xml.Header+`<result code="done"></result>` {
+			t.Fatalf("posts/add = %d %s", w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		params url.Values
+		code   int
+		want   string
+	}{
+		{
+			"add without a url", "/v1/posts/add", url.Values{"description": {"x"}, "format": {"json"}},
+			200, `{"result_code":"missing url"}`,
+		},
+		{
+			"add an existing url", "/v1/posts/add", url.Values{"url": {"https://example.com/"}, "description": {"x"}, "replace": {"no"}},
+			200, xml.Header + `<result code="item already exists"></result>`,
+		},
+		{
+			"get", "/v1/posts/get", url.Values{"url": {"https://go.dev/blog/pipelines"}},
+			200, xml.Header + `<posts user="anton" dt="2022-10-17T09:30:00Z">` +
+				`<post href="https://go.dev/blog/pipelines" description="Pipelines" extended="Fan-out &amp; fan-in" meta="*" ` +
+				`hash="*" time="2022-10-17T09:30:00Z" shared="yes" toread="yes" tag="go concurrency"></post></posts>`,
+		},
+		{
+			"get json", "/v1/posts/get", url.Values{"dt": {"2022-10-16"}, "format": {"json"}},
+			200, `{"user":"anton","date":"2022-10-16T00:00:00Z","posts":[{"href":"https://example.com/","description":"Example",` +
+				`"extended":"","meta":"*","hash":"*","time":"2022-10-16T12:00:00Z","shared":"no","toread":"no","tags":"go"}]}`,
+		},
+		{
+			"get a day without posts", "/v1/posts/get", url.Values{"dt": {"2022-10-15"}, "format": {"json"}},
+			200, `{"user":"anton","date":"2022-10-15T00:00:00Z","posts":[]}`,
+		},
+		{
+			"get with an invalid date", "/v1/posts/get", url.Values{"dt": {"yesterday"}},
+			400, xml.Header + `<result code="invalid dt"></result>`,
+		},
+		{
+			"all", "/v1/posts/all", url.Values{"tag": {"go"}, "results": {"1"}, "start": {"1"}},
+			200, xml.Header + `<posts user="anton"><post href="https://example.com/" description="Example" extended="" meta="*" ` +
+				`hash="*" time="2022-10-16T12:00:00Z" shared="no" toread="no" tag="go"></post></posts>`,
+		},
+		{
+			// posts/all is a bare array in JSON
+			"all json", "/v1/posts/all", url.Values{"tag": {"concurrency"}, "format": {"json"}},
+			200, `[{"href":"https://go.dev/blog/pipelines","description":"Pipelines","extended":"Fan-out \u0026 fan-in","meta":"*",` +
+				`"hash":"*","time":"2022-10-17T09:30:00Z","shared":"yes","toread":"yes","tags":"go concurrency"}]`,
+		},
+		{
+			"recent", "/v1/posts/recent", url.Values{"count": {"1"}, "format": {"json"}},
+			200, `{"user":"anton","date":"2022-10-17T09:30:00Z","posts":[{"href":"https://go.dev/blog/pipelines","description":"Pipelines",` +
+				`"extended":"Fan-out \u0026 fan-in","meta":"*","hash":"*","time":"2022-10-17T09:30:00Z","shared":"yes","toread":"yes","tags":"go concurrency"}]}`,
+		},
+		{
+			"tags", "/v1/tags/get", url.Values{},
+			200, xml.Header + `<tags><tag tag="concurrency" count="1"></tag><tag tag="go" count="2"></tag></tags>`,
+		},
+		{
+			// tags/get is an object of counts by name in JSON
+			"tags json", "/v1/tags/get", url.Values{"format": {"json"}},
+			200, `{"concurrency":1,"go":2}`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt.params.Set("auth_token", write)
+		w := call(tt.path, tt.params)
+
+		got := strings.TrimSpace(w.Body.String())
+		if w.Code != tt.code || !matchesPinboard(got, tt.want) {
+			t.Errorf("%s: got %d %s\nwant %d %s", tt.name, w.Code, got, tt.code, tt.want)
+		}
+	}
+
+	w := call("/v1/posts/update", url.Values{"auth_token": {read}, "format": {"json"}})
+	var update struct {
+		Time string `json:"update_time"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &update); err != nil || update.Time == "" {
+		t.Errorf("posts/update = %s", w.Body)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		path  string
+		token string
+		code  int
+	}{
+		{"no token", "/v1/posts/all", "", http.StatusUnauthorized},
+		{"wrong token", "/v1/posts/all", "anton:nope", http.StatusUnauthorized},
+		{"someone else's name", "/v1/posts/all", "other:" + tokens[data.SCOPE_READ], http.StatusUnauthorized},
+		{"read token writing", "/v1/posts/delete", read, http.StatusForbidden},
+		{"read token reading", "/v1/posts/all", read, http.StatusOK},
+	} {
+		if w := call(tt.path, url.Values{"auth_token": {tt.token}}); w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+}
+
+// matchesPinboard compares a response with want, where a quoted * matches
+// any quoted value since hashes aren't worth spelling out
+func matchesPinboard(got, want string) bool {
+	parts := strings.Split(want, `"*"`)
+	if !strings.HasPrefix(got, parts[0]) {
+		return false
+	}
+	got = got[len(parts[0]):]
+
+	for _, part := range parts[1:] {
+		if !strings.HasPrefix(got, `"`) {
+			return false
+		}
+
+		end := strings.Index(got[1:], `"`)
+		if end < 0 {
+			return false
+		}
+		got = got[end+2:]
+
+		if !strings.HasPrefix(got, part) {
+			return false
+		}
+		got = got[len(part):]
+	}
+
+	return got == ""
+}
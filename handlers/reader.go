@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
+)
+
+func registerReaderHandlers(r *http.ServeMux) {
+	r.HandleFunc("/read/", reader)
+}
+
+type withArticle struct {
+	Bookmark *data.Bookmark
+	Article  *data.Article
+}
+
+// reader shows the text of the page a bookmark points at without
+// anything else that was on it in /read/<id>
+func reader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		fmt.Printf("wrong request method: expected GET, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := parseIDFromPath(r, "/read/")
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	b, err := visibleBookmark(r, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	a, err := data.FetchArticle(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if a == nil || a.WordCount == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		switch {
+		case a == nil:
+			fmt.Fprintln(w, "The text of this page hasn't been extracted yet.")
+		default:
+			fmt.Fprintf(w, "There's no readable text for this page: %s\n", a.Error)
+		}
+		return
+	}
+
+	lib.RenderTemplate(w, r, "reader.html", lib.TemplateData{
+		Title: b.Title,
+		Data:  withArticle{Bookmark: b, Article: a},
+	})
+}
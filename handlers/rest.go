@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/jobs"
+)
+
+// MAX_BODY_SIZE limits how much of a request body the JSON API will read
+const MAX_BODY_SIZE = 1 << 20
+
+// byMethod dispatches a request to the handler registered for its method
+// and answers any other method with 405
+type byMethod map[string]http.HandlerFunc
+
+func (m byMethod) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := m[r.Method]; ok {
+		h(w, r)
+		return
+	}
+
+	allowed := []string{}
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+}
+
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("json.Encode: %v\n", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...any) {
+	e := apiError{}
+	e.Error.Status = status
+	e.Error.Message = fmt.Sprintf(format, args...)
+	writeJSON(w, status, e)
+}
+
+type apiBookmarkList struct {
+	Bookmarks []data.Bookmark `json:"bookmarks"`
+	data.Pagination
+}
+
+func writeBookmarkList(w http.ResponseWriter, bookmarks []data.Bookmark, pg data.Pagination) {
+	if bookmarks == nil {
+		bookmarks = []data.Bookmark{}
+	}
+	writeJSON(w, http.StatusOK, apiBookmarkList{Bookmarks: bookmarks, Pagination: pg})
+}
+
+// apiBookmarkInput is the body of POST and PATCH requests. Fields that are
+// left out keep their current value, or their default for new bookmarks.
+type apiBookmarkInput struct {
+	URL         *string   `json:"url"`
+	Title       *string   `json:"title"`
+	Shortcut    *string   `json:"shortcut"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Authors     *[]string `json:"authors"`
+	ToRead      *bool     `json:"toRead"`
+	IsPrivate   *bool     `json:"isPrivate"`
+}
+
+func decodeBookmarkInput(r *http.Request) (in apiBookmarkInput, err error) {
+	dec := json.NewDecoder(io.LimitReader(r.Body, MAX_BODY_SIZE))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&in); err != nil {
+		return in, fmt.Errorf("invalid JSON body: %v", err)
+	}
+
+	for _, list := range []*[]string{in.Tags, in.Authors} {
+		if list == nil {
+			continue
+		}
+
+		for _, t := range *list {
+			if t == "" || strings.ContainsAny(t, " \t\r\n") {
+				return in, fmt.Errorf("invalid tag %q: tags can't be empty or contain spaces", t)
+			}
+		}
+	}
+
+	return in, nil
+}
+
+func (in apiBookmarkInput) applyTo(b *data.Bookmark) {
+	if in.URL != nil {
+		b.URL = strings.TrimSpace(*in.URL)
+	}
+
+	if in.Title != nil {
+		b.Title = *in.Title
+	}
+
+	if in.Shortcut != nil {
+		b.Shortcut = strings.TrimSpace(*in.Shortcut)
+	}
+
+	if in.Description != nil {
+		b.Description = *in.Description
+	}
+
+	if in.Tags != nil || in.Authors != nil {
+		tags := b.ParseTags()
+		if in.Tags != nil {
+			tags = *in.Tags
+		}
+
+		authors := b.ParseAuthors()
+		if in.Authors != nil {
+			authors = *in.Authors
+		}
+
+		all := append([]string{}, tags...)
+		for _, a := range authors {
+			all = append(all, "by:"+strings.TrimPrefix(a, "by:"))
+		}
+		b.Tags = strings.Join(all, " ")
+	}
+
+	if in.ToRead != nil {
+		if *in.ToRead {
+			b.ReadAt = 0
+		} else if b.ReadAt == 0 {
+			b.ReadAt = time.Now().Unix()
+		}
+	}
+
+	if in.IsPrivate != nil {
+		b.IsPrivate = *in.IsPrivate
+	}
+}
+
+// apiQuery builds a search query out of the ?q=, ?tag=, ?author= and
+// ?unread= parameters. Tags and authors can be repeated and must all match.
+func apiQuery(r *http.Request) (q *data.Query, err error) {
+	params := r.URL.Query()
+
+	q, err = data.ParseQuery(params.Get("q"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range params["tag"] {
+		q.Terms = append(q.Terms, &data.TagTerm{Name: t})
+	}
+
+	for _, a := range params["author"] {
+		q.Terms = append(q.Terms, &data.TagTerm{Name: "by:" + a})
+	}
+
+	switch params.Get("unread") {
+	case "":
+	case "true":
+		q.Terms = append(q.Terms, &data.StateTerm{State: "unread"})
+	case "false":
+		q.Terms = append(q.Terms, &data.StateTerm{State: "unread", Negated: true})
+	default:
+		return nil, fmt.Errorf("invalid unread %q: expected true or false", params.Get("unread"))
+	}
+
+	return q, nil
+}
+
+// apiListBookmarks returns a page of bookmarks, newest first, optionally
+// filtered. ?trash=true lists the trash instead and can't be filtered.
+func apiListBookmarks(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	q, err := apiQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	var bookmarks []data.Bookmark
+	var pg data.Pagination
+
+	switch {
+	case r.URL.Query().Get("trash") == "true":
+		if !q.IsEmpty() {
+			writeAPIError(w, http.StatusBadRequest, "the trash can't be filtered")
+			return
+		}
+		bookmarks, pg, err = data.FetchDeletedBookmarks(scopeFor(r), p)
+	case q.IsEmpty():
+		bookmarks, pg, err = data.FetchAllBookmarks(scopeFor(r), p)
+	default:
+		bookmarks, pg, err = data.SearchBookmarks(scopeFor(r), q, p)
+	}
+
+	if errors.Is(err, data.ErrInvalidCursor) {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("apiListBookmarks: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	writeBookmarkList(w, bookmarks, pg)
+}
+
+func apiCreateBookmark(w http.ResponseWriter, r *http.Request) {
+	in, err := decodeBookmarkInput(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	b := data.Bookmark{ReadAt: time.Now().Unix()}
+	in.applyTo(&b)
+
+	if b.URL == "" || b.Title == "" {
+		writeAPIError(w, http.StatusBadRequest, "url and title are required")
+		return
+	}
+
+	existing, err := data.FetchBookmarkByURL(scopeFor(r), b.URL)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if existing != nil {
+		w.Header().Set("Location", fmt.Sprintf("/api/bookmarks/%d", existing.ID))
+		writeAPIError(w, http.StatusConflict, "url already saved as bookmark %d", existing.ID)
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	id, err := tx.AddBookmark(b)
+	if err != nil {
+		fmt.Printf("tx.AddBookmark: %v\n", err)
+		tx.Rollback()
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.QueueBookmarkJobs(id); err != nil {
+		fmt.Printf("tx.QueueBookmarkJobs: %v\n", err)
+		tx.Rollback()
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	jobs.Wake()
+
+	created, err := data.FetchBookmarkByID(scopeFor(r), id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/bookmarks/%d", id))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiFetchBookmark looks up the bookmark named by the request path and
+// writes an error if there isn't one
+func apiFetchBookmark(w http.ResponseWriter, r *http.Request) *data.Bookmark {
+	id, err := parseIDFromPath(r, "/api/bookmarks/")
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "bookmark not found")
+		return nil
+	}
+
+	b, err := data.FetchBookmarkByID(scopeFor(r), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, http.StatusNotFound, "bookmark not found")
+		} else {
+			writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		}
+		return nil
+	}
+
+	return b
+}
+
+func apiGetBookmark(w http.ResponseWriter, r *http.Request) {
+	if b := apiFetchBookmark(w, r); b != nil {
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+func apiUpdateBookmark(w http.ResponseWriter, r *http.Request) {
+	b := apiFetchBookmark(w, r)
+	if b == nil {
+		return
+	}
+
+	in, err := decodeBookmarkInput(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	in.applyTo(b)
+	if b.URL == "" || b.Title == "" {
+		writeAPIError(w, http.StatusBadRequest, "url and title can't be empty")
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.UpdateBookmark(*b); err != nil {
+		fmt.Printf("tx.UpdateBookmark: %v\n", err)
+		tx.Rollback()
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	jobs.Wake()
+
+	updated, err := data.FetchBookmarkByID(scopeFor(r), b.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// apiDeleteBookmark moves a bookmark to the trash, same as the delete
+// button in the UI
+func apiDeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	b := apiFetchBookmark(w, r)
+	if b == nil {
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.DeleteBookmark(b.ID); err != nil {
+		fmt.Printf("tx.DeleteBookmark: %v\n", err)
+		tx.Rollback()
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiTagList struct {
+	Tags []data.Tag `json:"tags"`
+}
+
+// apiListTags returns every tag and author in use, sorted by name.
+// Authors have isAuthor set and their names start with "by:".
+func apiListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := data.FetchAllTags(scopeFor(r))
+	if err != nil {
+		fmt.Printf("data.FetchAllTags: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	authors, err := data.FetchAllAuthors(scopeFor(r))
+	if err != nil {
+		fmt.Printf("data.FetchAllAuthors: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	res := apiTagList{Tags: append([]data.Tag{}, tags...)}
+	res.Tags = append(res.Tags, authors...)
+	sort.Slice(res.Tags, func(i, j int) bool {
+		return res.Tags[i].Name < res.Tags[j].Name
+	})
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// apiTagBookmarks serves /api/tags/{name}/bookmarks
+func apiTagBookmarks(w http.ResponseWriter, r *http.Request) {
+	// Tag names can contain slashes, so the name has to be cut out of the
+	// path before it's unescaped
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), "/api/tags/")
+	if !strings.HasSuffix(rest, "/bookmarks") || rest == "/bookmarks" {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	name, err := url.PathUnescape(strings.TrimSuffix(rest, "/bookmarks"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid tag name")
+		return
+	}
+
+	p, err := parsePageFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	bookmarks, pg, err := data.FetchBookmarksByTag(scopeFor(r), name, p)
+	if errors.Is(err, data.ErrInvalidCursor) {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("data.FetchBookmarksByTag: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	writeBookmarkList(w, bookmarks, pg)
+}
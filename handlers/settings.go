@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
+)
+
+func registerSettingsHandlers(r *http.ServeMux) {
+	r.HandleFunc("/settings/", settings)
+}
+
+type withSettings struct {
+	Tokens *[]data.APIToken
+	Scopes []string
+
+	// NewToken is only set right after a token was created since that's
+	// the only time it can be shown
+	NewToken     string
+	NewTokenName string
+	Error        string
+
+	// ArchiveQuota and ArchiveUsage are in megabytes
+	ArchiveQuota int64
+	ArchiveUsage string
+
+	IsAdmin bool
+}
+
+// renderSettings writes the settings page with the given status, or a 500
+// if loading it fails
+func renderSettings(w http.ResponseWriter, r *http.Request, status int, d withSettings) {
+	s := lib.GetSession(r.Context())
+	u, err := data.FetchUserByID(s.UserID)
+	if err != nil {
+		fmt.Printf("data.FetchUserByID: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := data.FetchAPITokens(s.UserID)
+	if err != nil {
+		fmt.Printf("data.FetchAPITokens: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	quota, err := data.FetchArchiveQuota(s.UserID)
+	if err != nil {
+		fmt.Printf("data.FetchArchiveQuota: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	usage, err := data.ArchiveUsage(s.UserID, 0)
+	if err != nil {
+		fmt.Printf("data.ArchiveUsage: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	d.Tokens = &tokens
+	d.Scopes = data.SCOPES
+	d.ArchiveQuota = quota >> 20
+	d.ArchiveUsage = fmt.Sprintf("%.1f", float64(usage)/(1<<20))
+	d.IsAdmin = u.IsAdmin
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	lib.RenderTemplate(w, r, "settings.html", lib.TemplateData{
+		Title: "bland: settings",
+		Data:  d,
+	})
+}
+
+func settings(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/settings/" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+
+	if r.Method == "GET" {
+		renderSettings(w, r, http.StatusOK, withSettings{})
+		return
+	}
+
+	if r.Method != "POST" {
+		fmt.Printf("wrong request method: expected GET/POST, got %s\n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tx, err := data.BeginTx(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "create-token":
+		name := r.FormValue("name")
+		token, err := tx.AddAPIToken(name, []string{r.FormValue("scope")})
+		if err != nil {
+			tx.Rollback()
+			renderSettings(w, r, http.StatusBadRequest, withSettings{Error: err.Error()})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		renderSettings(w, r, http.StatusOK, withSettings{NewToken: token, NewTokenName: name})
+		return
+
+	case "revoke-token":
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			tx.Rollback()
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := tx.RevokeAPIToken(id); err != nil {
+			tx.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			fmt.Printf("tx.RevokeAPIToken: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+	case "set-archive-quota":
+		// The quota is entered in megabytes, so anything that doesn't fit
+		// in bytes would wrap around
+		quota, err := strconv.ParseInt(r.FormValue("quota"), 10, 64)
+		if err == nil && (quota < 0 || quota > math.MaxInt64>>20) {
+			err = errors.New("archive quota out of range")
+		}
+
+		if err == nil {
+			err = tx.SetArchiveQuota(quota << 20)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			renderSettings(w, r, http.StatusBadRequest, withSettings{Error: "invalid archive quota"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		tx.Rollback()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/", http.StatusSeeOther)
+}
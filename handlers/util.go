@@ -1,13 +1,37 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
 )
 
+// scopeFor returns the bookmarks the visitor is allowed to see. Under
+// /u/<name>/ that's the public part of name's library. Elsewhere logged in
+// users see their own library and everyone else the public bookmarks of
+// all users.
+func scopeFor(r *http.Request) data.Scope {
+	ctx := r.Context()
+	if o := lib.GetOwner(ctx); o != nil {
+		return data.Scope{UserID: o.UserID}
+	}
+
+	// Feeds end up in feed readers and their caches, so they never have
+	// private bookmarks even if whoever asked for one is logged in
+	if s := lib.GetSession(ctx); s != nil && feedFormat(r) == "" {
+		return data.OwnScope(s.UserID)
+	}
+
+	return data.Scope{}
+}
+
 func parseIDFromRequest(r *http.Request) (id int64, err error) {
 	if r.Method != "POST" {
 		return 0, fmt.Errorf("wrong request method: expected POST, got %s", r.Method)
@@ -31,3 +55,59 @@ func parseIDFromPath(r *http.Request, base string) (id int64, err error) {
 	id, err = strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, base), "/"), 10, 64)
 	return
 }
+
+// parsePageFromRequest reads the ?before=, ?after= and ?limit= parameters
+// shared by all paginated listings
+func parsePageFromRequest(r *http.Request) (p data.Page, err error) {
+	q := r.URL.Query()
+
+	if s := q.Get("before"); s != "" {
+		if p.Before, err = data.ParseCursor(s); err != nil {
+			return p, err
+		}
+	}
+
+	if s := q.Get("after"); s != "" {
+		if p.After, err = data.ParseCursor(s); err != nil {
+			return p, err
+		}
+	}
+
+	if s := q.Get("limit"); s != "" {
+		if p.Limit, err = strconv.Atoi(s); err != nil || p.Limit <= 0 {
+			return p, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+
+	return p, nil
+}
+
+// fetchErrorStatus is the status to respond with when fetching a listing
+// failed. Cursors that don't belong to it are the client's fault.
+func fetchErrorStatus(err error) int {
+	if errors.Is(err, data.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// pageURL returns the current URL with the pagination cursor replaced,
+// keeping any other query parameters (such as ?q= or ?limit=) intact
+func pageURL(r *http.Request, key string, c *data.Cursor) string {
+	if c == nil {
+		return ""
+	}
+
+	q := r.URL.Query()
+	q.Del("before")
+	q.Del("after")
+	q.Set(key, c.String())
+
+	path := r.URL.Path
+	if o := lib.GetOwner(r.Context()); o != nil {
+		path = o.BasePath() + path
+	}
+
+	u := url.URL{Path: path, RawQuery: q.Encode()}
+	return u.String()
+}
@@ -0,0 +1,296 @@
+// Package jobs runs the work queued in the jobs table in the background
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/valueof/bland/data"
+)
+
+// JOB_TIMEOUT is how long a single attempt may take before it's cancelled
+const JOB_TIMEOUT = 3 * time.Minute
+
+// POLL_INTERVAL is how often idle workers look for jobs that became due
+const POLL_INTERVAL = 5 * time.Second
+
+// RETRY_DELAY is how long to wait before the second attempt, doubling
+// after that up to MAX_RETRY_DELAY
+const RETRY_DELAY = 30 * time.Second
+const MAX_RETRY_DELAY = time.Hour
+
+// Handler does the work of a job and returns a result to save as JSON
+type Handler func(ctx context.Context, j *data.Job) (result any, err error)
+
+var handlers = map[string]Handler{}
+
+// Register sets the handler for a kind of job
+func Register(kind string, h Handler) {
+	handlers[kind] = h
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error that retrying won't fix
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+type postponedError struct {
+	delay time.Duration
+}
+
+func (e *postponedError) Error() string {
+	return fmt.Sprintf("postponed for %v", e.delay)
+}
+
+// Postpone puts a job back in the queue to run after delay, without
+// counting it as an attempt
+func Postpone(delay time.Duration) error {
+	return &postponedError{delay}
+}
+
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+var wake = make(chan struct{}, 1)
+
+// Wake tells an idle worker to look for jobs right away
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Queue is a set of workers running jobs
+type Queue struct {
+	logger *log.Logger
+
+	// stopping stops claiming jobs, cancelling ctx interrupts running ones
+	stopping chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// Start requeues jobs left running by a previous process and starts the
+// given number of workers
+func Start(logger *log.Logger, workers int) (*Queue, error) {
+	tx, err := data.BeginTx(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := tx.ReleaseRunningJobs()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if n > 0 {
+		logger.Printf("requeued %d job(s) that were interrupted", n)
+	}
+
+	q := &Queue{
+		logger:   logger,
+		stopping: make(chan struct{}),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q, nil
+}
+
+// Stop waits for running jobs to finish, or until ctx is done, and then
+// puts the rest back in the queue
+func (q *Queue) Stop(ctx context.Context) {
+	close(q.stopping)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.logger.Println("interrupting running jobs")
+		q.cancel()
+		<-done
+	}
+
+	q.cancel()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.stopping:
+			return
+		default:
+		}
+
+		j, err := data.ClaimJob()
+		if err == nil {
+			// There may be more where that one came from
+			Wake()
+			q.run(j)
+			continue
+		}
+
+		if err != sql.ErrNoRows {
+			q.logger.Printf("jobs: %v", err)
+		}
+
+		select {
+		case <-q.stopping:
+			return
+		case <-wake:
+		case <-time.After(POLL_INTERVAL):
+		}
+	}
+}
+
+func (q *Queue) run(j *data.Job) {
+	var result any
+	var err error
+
+	h, ok := handlers[j.Kind]
+	if ok {
+		result, err = q.call(h, j)
+	} else {
+		err = Permanent(fmt.Errorf("unknown job kind %q", j.Kind))
+	}
+
+	// Bookkeeping outlives the queue
+	tx, txErr := data.BeginTx(context.Background())
+	if txErr != nil {
+		q.logger.Printf("jobs(%d): %v", j.ID, txErr)
+		return
+	}
+
+	var crashed *panicError
+	var permanent *permanentError
+	var postponed *postponedError
+	switch {
+	case errors.As(err, &crashed):
+		// It would most likely panic again
+		txErr = tx.FailJob(j.ID, err.Error())
+	case err != nil && q.ctx.Err() != nil:
+		txErr = tx.ReleaseJob(j.ID, j.RunAt)
+	case errors.As(err, &postponed):
+		txErr = tx.ReleaseJob(j.ID, time.Now().Add(postponed.delay).Unix())
+	case err == nil:
+		var out []byte
+		if out, txErr = json.Marshal(result); txErr == nil {
+			txErr = tx.FinishJob(j.ID, string(out))
+		}
+	case errors.As(err, &permanent) || j.LastAttempt():
+		q.logger.Printf("%s job %d failed: %v", j.Kind, j.ID, err)
+		txErr = tx.FailJob(j.ID, err.Error())
+	default:
+		txErr = tx.RetryJob(j.ID, err.Error(), time.Now().Add(retryDelay(j.Attempts)).Unix())
+	}
+
+	if txErr == nil {
+		txErr = tx.RequeueJob(j.ID)
+	}
+
+	if txErr != nil {
+		q.logger.Printf("jobs(%d): %v", j.ID, txErr)
+		tx.Rollback()
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		q.logger.Printf("jobs(%d): %v", j.ID, err)
+	}
+}
+
+// call runs the handler of a job, turning a panic into an error
+func (q *Queue) call(h Handler, j *data.Job) (result any, err error) {
+	ctx, cancel := context.WithTimeout(q.ctx, JOB_TIMEOUT)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			q.logger.Printf("%s job %d panicked: %v\n%s", j.Kind, j.ID, r, debug.Stack())
+			result, err = nil, &panicError{r}
+		}
+	}()
+
+	return h(ctx, j)
+}
+
+// retryDelay is how long to wait after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	delay := RETRY_DELAY
+	for i := 1; i < attempts && delay < MAX_RETRY_DELAY; i++ {
+		delay *= 2
+	}
+
+	if delay > MAX_RETRY_DELAY {
+		return MAX_RETRY_DELAY
+	}
+	return delay
+}
+
+// Await waits up to timeout for a job of the given user, or anyone's if
+// userID is 0, to finish and returns it as it was last seen
+func Await(ctx context.Context, userID, id int64, timeout time.Duration) (j *data.Job, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		j, err = data.FetchJob(userID, id)
+		if err != nil || j.Status == data.JOB_DONE || j.Status == data.JOB_FAILED {
+			return j, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return j, nil
+		case <-ticker.C:
+		}
+	}
+}
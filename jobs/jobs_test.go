@@ -0,0 +1,285 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/setup"
+)
+
+// openTestDB connects the data package to a new database with every
+// migration applied and returns a second connection to it for poking at
+// the jobs table directly
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	fp := filepath.Join(t.TempDir(), "bland.db")
+	db, err := sql.Open("sqlite3", fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := setup.LoadMigrations(os.DirFS("../sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setup.Migrate(db, migrations, func(string, ...any) {}); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			t.Skip("needs -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+
+	if err := data.ConnectToDB(fp); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// useHandler registers h for archive jobs for the duration of the test
+func useHandler(t *testing.T, h Handler) {
+	old, ok := handlers[data.JOB_ARCHIVE]
+	Register(data.JOB_ARCHIVE, h)
+	t.Cleanup(func() {
+		if ok {
+			handlers[data.JOB_ARCHIVE] = old
+		} else {
+			delete(handlers, data.JOB_ARCHIVE)
+		}
+	})
+}
+
+func enqueue(t *testing.T, key string) int64 {
+	t.Helper()
+
+	tx, err := data.BeginTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := tx.EnqueueJob(data.JOB_ARCHIVE, key, data.BookmarkJob{BookmarkID: 1})
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func fetch(t *testing.T, id int64) *data.Job {
+	t.Helper()
+
+	j, err := data.FetchJob(0, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func newTestQueue() *Queue {
+	q := &Queue{
+		logger:   log.New(io.Discard, "", 0),
+		stopping: make(chan struct{}),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	return q
+}
+
+// claimAndRun runs the next job that's due the way a worker would
+func claimAndRun(t *testing.T, q *Queue) *data.Job {
+	t.Helper()
+
+	j, err := data.ClaimJob()
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+
+	q.run(j)
+	return fetch(t, j.ID)
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, RETRY_DELAY},
+		{1, RETRY_DELAY},
+		{2, 2 * RETRY_DELAY},
+		{3, 4 * RETRY_DELAY},
+		{7, 64 * RETRY_DELAY},
+		{8, MAX_RETRY_DELAY},
+		{1000, MAX_RETRY_DELAY},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+
+		status    string
+		attempts  int
+		lastError string
+		result    string
+		delay     time.Duration
+	}{
+		{
+			name:    "done",
+			handler: func(ctx context.Context, j *data.Job) (any, error) { return map[string]int{"size": 42}, nil },
+			status:  data.JOB_DONE, attempts: 1, result: `{"size":42}`,
+		},
+		{
+			name:    "retried",
+			handler: func(ctx context.Context, j *data.Job) (any, error) { return nil, errors.New("timeout") },
+			status:  data.JOB_PENDING, attempts: 1, lastError: "timeout", delay: RETRY_DELAY,
+		},
+		{
+			name:    "permanent",
+			handler: func(ctx context.Context, j *data.Job) (any, error) { return nil, Permanent(errors.New("gone")) },
+			status:  data.JOB_FAILED, attempts: 1, lastError: "gone",
+		},
+		{
+			name:    "postponed",
+			handler: func(ctx context.Context, j *data.Job) (any, error) { return nil, Postpone(time.Hour) },
+			status:  data.JOB_PENDING, attempts: 0, delay: time.Hour,
+		},
+		{
+			name:    "panic",
+			handler: func(ctx context.Context, j *data.Job) (any, error) { panic("boom") },
+			status:  data.JOB_FAILED, attempts: 1, lastError: "panic: boom",
+		},
+	}
+
+	for _, tt := range tests {
+		openTestDB(t)
+		useHandler(t, tt.handler)
+
+		id := enqueue(t, tt.name)
+		start := time.Now().Unix()
+		j := claimAndRun(t, newTestQueue())
+
+		if j.ID != id || j.Status != tt.status || j.Attempts != tt.attempts || j.LastError != tt.lastError || j.Result != tt.result {
+			t.Errorf("%s: got %s after %d attempt(s) with error %q and result %q, want %s after %d with %q and %q",
+				tt.name, j.Status, j.Attempts, j.LastError, j.Result, tt.status, tt.attempts, tt.lastError, tt.result)
+		}
+
+		if tt.delay > 0 {
+			want := start + int64(tt.delay.Seconds())
+			if j.RunAt < want || j.RunAt > want+1 {
+				t.Errorf("%s: runs %ds from now, want %v", tt.name, j.RunAt-start, tt.delay)
+			}
+		}
+	}
+}
+
+func TestRunGivesUp(t *testing.T) {
+	db := openTestDB(t)
+
+	calls := 0
+	useHandler(t, func(ctx context.Context, j *data.Job) (any, error) {
+		calls++
+		return nil, errors.New("timeout")
+	})
+
+	id := enqueue(t, "1")
+	q := newTestQueue()
+	for i := 1; i <= data.JOB_OPTIONS[data.JOB_ARCHIVE].MaxAttempts; i++ {
+		j := claimAndRun(t, q)
+
+		want := data.JOB_PENDING
+		if j.LastAttempt() {
+			want = data.JOB_FAILED
+		}
+
+		if j.Attempts != i || j.Status != want {
+			t.Fatalf("attempt %d: %s after %d attempt(s), want %s", i, j.Status, j.Attempts, want)
+		}
+
+		// Don't wait for the backoff
+		if _, err := db.Exec(`update jobs set run_at = 0 where id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := data.ClaimJob(); err != sql.ErrNoRows {
+		t.Errorf("failed job was claimed again: %v", err)
+	}
+
+	if calls != data.JOB_OPTIONS[data.JOB_ARCHIVE].MaxAttempts {
+		t.Errorf("handler ran %d times", calls)
+	}
+}
+
+func TestRunRerunsJobQueuedWhileRunning(t *testing.T) {
+	openTestDB(t)
+
+	useHandler(t, func(ctx context.Context, j *data.Job) (any, error) {
+		if id := enqueue(t, "1"); id != j.ID {
+			t.Errorf("queueing a running job added job %d instead of returning %d", id, j.ID)
+		}
+		return "stale", nil
+	})
+
+	id := enqueue(t, "1")
+	j := claimAndRun(t, newTestQueue())
+	if j.ID != id || j.Status != data.JOB_PENDING || j.Attempts != 0 || j.Result != "" {
+		t.Errorf("got %s after %d attempt(s) with result %q, want it pending again", j.Status, j.Attempts, j.Result)
+	}
+}
+
+func TestStop(t *testing.T) {
+	openTestDB(t)
+
+	running := make(chan struct{})
+	useHandler(t, func(ctx context.Context, j *data.Job) (any, error) {
+		close(running)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	q, err := Start(log.New(io.Discard, "", 0), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := enqueue(t, "1")
+	Wake()
+
+	select {
+	case <-running:
+	case <-time.After(2 * POLL_INTERVAL):
+		t.Fatal("job didn't start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	q.Stop(ctx)
+
+	// Interrupted jobs go back in the queue as if they never ran
+	if j := fetch(t, id); j.Status != data.JOB_PENDING || j.Attempts != 0 || j.LastError != "" {
+		t.Errorf("got %s after %d attempt(s) with error %q, want it pending", j.Status, j.Attempts, j.LastError)
+	}
+}
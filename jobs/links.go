@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/metadata"
+)
+
+// LINK_CHECK_HOST_INTERVAL is how long the link checker waits between
+// requests to the same host
+const LINK_CHECK_HOST_INTERVAL = 5 * time.Second
+
+func init() {
+	Register(data.JOB_LINKCHECK, checkLink)
+}
+
+// hostLimiter hands out the times requests to each host may be made at
+type hostLimiter struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+var linkCheckLimiter = &hostLimiter{next: map[string]time.Time{}}
+
+// reserve returns how long to wait before making a request to host, and
+// reserves that time unless it's longer than max
+func (l *hostLimiter) reserve(host string, max time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.next) > 1000 {
+		for h, t := range l.next {
+			if t.Before(now) {
+				delete(l.next, h)
+			}
+		}
+	}
+
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+
+	wait := at.Sub(now)
+	if wait <= max {
+		l.next[host] = at.Add(LINK_CHECK_HOST_INTERVAL)
+	}
+	return wait
+}
+
+// checkLink requests the URL of a bookmark and records the response
+func checkLink(ctx context.Context, j *data.Job) (any, error) {
+	var p data.BookmarkJob
+	if err := json.Unmarshal([]byte(j.Payload), &p); err != nil {
+		return nil, Permanent(err)
+	}
+
+	b, err := data.FetchBookmarkByID(data.Scope{Private: true}, p.BookmarkID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Don't hold up the worker, and don't bring every job back at once
+	wait := linkCheckLimiter.reserve(b.Host(), LINK_CHECK_HOST_INTERVAL)
+	if wait > LINK_CHECK_HOST_INTERVAL {
+		return nil, Postpone(wait + time.Duration(rand.Int63n(int64(wait))))
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(wait):
+	}
+
+	c := data.LinkCheck{URL: b.URL}
+	link, err := metadata.Check(ctx, b.URL)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	switch {
+	case err == nil:
+		status := &metadata.StatusError{StatusCode: link.StatusCode}
+		if link.StatusCode >= 400 && !giveUp(j, status) {
+			return nil, status
+		}
+		c.StatusCode = link.StatusCode
+		c.FinalURL = link.URL.String()
+	case errors.Is(err, metadata.ErrForbiddenAddress), errors.Is(err, metadata.ErrUnsupportedScheme):
+		// Links bland may not follow aren't broken
+	case !giveUp(j, err):
+		return nil, err
+	default:
+		c.Error = linkError(err)
+	}
+
+	if err := save(func(tx *data.Tx) error { return tx.SaveLinkCheck(b.ID, c) }); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"status": c.StatusCode, "url": c.FinalURL, "error": c.Error}, nil
+}
+
+// linkError describes why there was no response in fewer words than
+// net/http
+func linkError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return "host not found"
+	case errors.As(err, &dnsErr):
+		return "could not look up host"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	case errors.Is(err, metadata.ErrTooManyRedirects):
+		return metadata.ErrTooManyRedirects.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,193 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/valueof/bland/archive"
+	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/metadata"
+)
+
+func init() {
+	Register(data.JOB_METADATA, fetchMetadata)
+	Register(data.JOB_FAVICON, fetchFavicon)
+	Register(data.JOB_ARCHIVE, archivePage)
+	Register(data.JOB_ARTICLE, extractArticle)
+}
+
+// giveUp reports whether a failed fetch isn't worth retrying
+func giveUp(j *data.Job, err error) bool {
+	return !metadata.IsTemporary(err) || j.LastAttempt()
+}
+
+// save runs f in a transaction that isn't tied to ctx
+func save(f func(tx *data.Tx) error) error {
+	tx, err := data.BeginTx(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// fetchMetadata looks up a page for the bookmark form
+func fetchMetadata(ctx context.Context, j *data.Job) (any, error) {
+	var p data.MetadataJob
+	if err := json.Unmarshal([]byte(j.Payload), &p); err != nil {
+		return nil, Permanent(err)
+	}
+
+	m, err := metadata.Fetch(ctx, p.URL)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	return m, nil
+}
+
+// fetchFavicon looks for the icon of a host, remembering ones without
+func fetchFavicon(ctx context.Context, j *data.Job) (any, error) {
+	var p data.FaviconJob
+	if err := json.Unmarshal([]byte(j.Payload), &p); err != nil {
+		return nil, Permanent(err)
+	}
+
+	f := data.Favicon{Host: p.Host}
+	icon, err := metadata.FetchIcon(ctx, p.Host)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if err != nil && !errors.Is(err, metadata.ErrNoIcon) && !giveUp(j, err) {
+		return nil, err
+	}
+
+	found := err == nil
+	if found {
+		f.ContentType = icon.ContentType
+		f.Data = icon.Data
+	}
+
+	if err := save(func(tx *data.Tx) error { return tx.SaveFavicon(f) }); err != nil {
+		return nil, err
+	}
+
+	return map[string]bool{"found": found}, nil
+}
+
+// archivePage saves a copy of a bookmarked page if it fits in the quota
+func archivePage(ctx context.Context, j *data.Job) (any, error) {
+	var p data.BookmarkJob
+	if err := json.Unmarshal([]byte(j.Payload), &p); err != nil {
+		return nil, Permanent(err)
+	}
+
+	a, err := data.FetchArchiveRequest(p.BookmarkID)
+	if err == sql.ErrNoRows {
+		// The bookmark was deleted in the meantime
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	quota, err := data.FetchArchiveQuota(a.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := data.ArchiveUsage(a.UserID, a.BookmarkID)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot *archive.Snapshot
+	var failure error
+	reason := ""
+
+	if quota == 0 {
+		reason = "archiving is turned off in settings"
+	} else if usage >= quota {
+		reason = fmt.Sprintf("the archive quota of %dMB is used up", quota>>20)
+	} else if snapshot, err = archive.Capture(ctx, a.URL); err != nil {
+		if ctx.Err() != nil || !giveUp(j, err) {
+			return nil, err
+		}
+		reason = err.Error()
+		failure = Permanent(err)
+	} else if usage+int64(len(snapshot.Data)) > quota {
+		reason = fmt.Sprintf("the archived copy doesn't fit in the archive quota of %dMB", quota>>20)
+	}
+
+	err = save(func(tx *data.Tx) error {
+		if reason != "" {
+			return tx.FailArchive(a.BookmarkID, reason)
+		}
+		return tx.SaveArchive(data.Archive{BookmarkID: a.BookmarkID, URL: snapshot.URL, Data: snapshot.Data})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	// Running out of quota isn't a failed job
+	if reason != "" {
+		return map[string]string{"skipped": reason}, nil
+	}
+	return map[string]int{"size": len(snapshot.Data)}, nil
+}
+
+// extractArticle saves the readable text of a bookmarked page
+func extractArticle(ctx context.Context, j *data.Job) (any, error) {
+	var p data.BookmarkJob
+	if err := json.Unmarshal([]byte(j.Payload), &p); err != nil {
+		return nil, Permanent(err)
+	}
+
+	b, err := data.FetchBookmarkByID(data.Scope{Private: true}, p.BookmarkID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	a := data.Article{BookmarkID: b.ID, URL: b.URL}
+	article, err := metadata.FetchArticle(ctx, b.URL)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var failure error
+	if err != nil {
+		if !errors.Is(err, metadata.ErrNoArticle) {
+			if !giveUp(j, err) {
+				return nil, err
+			}
+			failure = Permanent(err)
+		}
+		a.Error = err.Error()
+	} else {
+		a.Content = article.Content
+		a.Text = article.Text
+		a.WordCount = article.WordCount
+	}
+
+	if err := save(func(tx *data.Tx) error { return tx.SaveArticle(a) }); err != nil {
+		return nil, err
+	}
+
+	if failure != nil {
+		return nil, failure
+	}
+	return map[string]int{"wordCount": a.WordCount}, nil
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/valueof/bland/data"
+)
+
+// KEEP_FINISHED is how long jobs that are done stay around
+const KEEP_FINISHED = 24 * time.Hour
+
+// Schedule periodically queues jobs for bookmarks that missed them and
+// links that are due, and clears out old jobs, until ctx is cancelled
+func Schedule(ctx context.Context, logger *log.Logger) {
+	schedule := func() {
+		hosts, err := data.HostsWithoutFavicon(50)
+		if err != nil {
+			logger.Printf("jobs.Schedule: %v", err)
+			return
+		}
+
+		bookmarks, err := data.BookmarksWithoutArticle(50)
+		if err != nil {
+			logger.Printf("jobs.Schedule: %v", err)
+			return
+		}
+
+		unchecked, err := data.BookmarksToCheck(100)
+		if err != nil {
+			logger.Printf("jobs.Schedule: %v", err)
+			return
+		}
+
+		tx, err := data.BeginTx(ctx)
+		if err != nil {
+			logger.Printf("jobs.Schedule: %v", err)
+			return
+		}
+
+		for _, host := range hosts {
+			if _, err = tx.EnqueueJob(data.JOB_FAVICON, host, data.FaviconJob{Host: host}); err != nil {
+				break
+			}
+		}
+
+		for _, b := range bookmarks {
+			if err != nil {
+				break
+			}
+			_, err = tx.EnqueueJob(data.JOB_ARTICLE, fmt.Sprint(b.ID), data.BookmarkJob{BookmarkID: b.ID})
+		}
+
+		for _, b := range unchecked {
+			if err != nil {
+				break
+			}
+			_, err = tx.EnqueueJob(data.JOB_LINKCHECK, fmt.Sprint(b.ID), data.BookmarkJob{BookmarkID: b.ID})
+		}
+
+		var n int64
+		if err == nil {
+			n, err = tx.PurgeFinishedJobs(time.Now().Add(-KEEP_FINISHED).Unix())
+		}
+
+		if err != nil {
+			logger.Printf("jobs.Schedule: %v", err)
+			tx.Rollback()
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			logger.Printf("jobs.Schedule: %v", err)
+			return
+		}
+
+		if n > 0 {
+			logger.Printf("purged %d finished job(s)", n)
+		}
+
+		Wake()
+	}
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		schedule()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
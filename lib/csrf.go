@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+const CSRF_KEY key = 3
+
+const CSRF_COOKIE = "bland_csrf"
+const CSRF_HEADER = "X-CSRF-Token"
+const CSRF_FIELD = "csrf_token"
+
+// NewCSRFNonce returns a random value for the CSRF cookie. The nonce never
+// leaves the cookie; pages only ever see tokens derived from it.
+func NewCSRFNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func SetCSRFCookie(w http.ResponseWriter, r *http.Request, nonce string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRF_COOKIE,
+		Value:    nonce,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   IsSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CSRFToken derives the token forms and scripts have to send back from the
+// browser's nonce and the logged in user, if any, so that logging in or out
+// invalidates tokens handed out before
+func CSRFToken(secret []byte, nonce string, s *Session) string {
+	var userID int64
+	if s != nil {
+		userID = s.UserID
+	}
+
+	mac := sign(secret, fmt.Sprintf("csrf|%s|%d", nonce, userID))
+	return base64.RawURLEncoding.EncodeToString(mac)
+}
+
+func ValidCSRFToken(secret []byte, nonce string, s *Session, token string) bool {
+	if nonce == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(CSRFToken(secret, nonce, s)))
+}
+
+// GetCSRFToken returns the token for the current request so it can be
+// embedded into pages
+func GetCSRFToken(ctx context.Context) string {
+	token, ok := ctx.Value(CSRF_KEY).(string)
+	if !ok {
+		return ""
+	}
+	return token
+}
@@ -62,6 +62,19 @@ type TemplateData struct {
 	Path  string
 	Title string
 	Host  string
+	Query string
+	User  string
+
+	// Owner and Base are set when browsing someone's library under
+	// /u/<name>/, Base being the prefix for links within that library
+	Owner string
+	Base  string
+
+	// CSRFToken has to be sent along with every form submission
+	CSRFToken string
+
+	// Feed is the Atom feed of the listing on the page, if there is one
+	Feed string
 }
 
 func RenderTemplate(w http.ResponseWriter, r *http.Request, name string, data TemplateData) {
@@ -87,6 +100,14 @@ func RenderTemplate(w http.ResponseWriter, r *http.Request, name string, data Te
 
 	data.Host = r.Host
 	data.Path = r.URL.Path
+	data.CSRFToken = GetCSRFToken(ctx)
+	if s := GetSession(ctx); s != nil {
+		data.User = s.UserName
+	}
+	if o := GetOwner(ctx); o != nil {
+		data.Owner = o.UserName
+		data.Base = o.BasePath()
+	}
 
 	err = t.Execute(w, data)
 	if err != nil {
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SESSION_KEY key = 2
+
+const SESSION_COOKIE = "bland_session"
+const SESSION_MAX_AGE = 30 * 24 * time.Hour
+
+var ErrInvalidSession = errors.New("invalid session")
+
+// Session identifies the logged in user. It's stored client side in a
+// cookie signed with the server's session key so it can't be tampered with,
+// or built from an API token by the authentication middleware.
+type Session struct {
+	UserID   int64
+	UserName string
+	Expires  int64
+
+	// Version is the user's session version at login. Bumping it in the
+	// database invalidates the cookie.
+	Version int64
+
+	// TokenID and Scopes are only set for requests authenticated with an
+	// API token instead of a cookie
+	TokenID int64
+	Scopes  []string
+}
+
+func NewSession(userID int64, userName string, version int64) Session {
+	return Session{
+		UserID:   userID,
+		UserName: userName,
+		Expires:  time.Now().Add(SESSION_MAX_AGE).Unix(),
+		Version:  version,
+	}
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Encode serializes and signs the session as id|name|expires|version
+// followed by an HMAC-SHA256 of it, both base64 encoded
+func (s Session) Encode(secret []byte) string {
+	payload := fmt.Sprintf("%d|%s|%d|%d", s.UserID, s.UserName, s.Expires, s.Version)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(sign(secret, payload))
+}
+
+// DecodeSession verifies and parses a value produced by Session.Encode.
+// Expired sessions are rejected.
+func DecodeSession(secret []byte, value string) (s Session, err error) {
+	enc := base64.RawURLEncoding
+
+	p, m, ok := strings.Cut(value, ".")
+	if !ok {
+		return s, ErrInvalidSession
+	}
+
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return s, ErrInvalidSession
+	}
+
+	mac, err := enc.DecodeString(m)
+	if err != nil || !hmac.Equal(mac, sign(secret, string(payload))) {
+		return s, ErrInvalidSession
+	}
+
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 4 {
+		return s, ErrInvalidSession
+	}
+
+	s.UserName = parts[1]
+	s.UserID, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return s, ErrInvalidSession
+	}
+
+	s.Expires, err = strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || s.Expires < time.Now().Unix() {
+		return s, ErrInvalidSession
+	}
+
+	s.Version, err = strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return s, ErrInvalidSession
+	}
+
+	return s, nil
+}
+
+// IsSecure reports whether the request reached us over https, either
+// directly or through a reverse proxy
+func IsSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, secret []byte, s Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    s.Encode(secret),
+		Path:     "/",
+		Expires:  time.Unix(s.Expires, 0),
+		HttpOnly: true,
+		Secure:   IsSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   IsSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// GetSession returns the session of the logged in user or nil if the
+// request is anonymous
+func GetSession(ctx context.Context) *Session {
+	s, ok := ctx.Value(SESSION_KEY).(*Session)
+	if !ok {
+		return nil
+	}
+	return s
+}
+
+func IsLoggedIn(ctx context.Context) bool {
+	return GetSession(ctx) != nil
+}
+
+// WithSession returns a copy of ctx acting on behalf of s. It's meant for
+// code that runs outside of a request, like importers.
+func WithSession(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, SESSION_KEY, &s)
+}
+
+const OWNER_KEY key = 4
+
+// Owner is the user whose library is being browsed under /u/<name>/
+type Owner struct {
+	UserID   int64
+	UserName string
+}
+
+func (o *Owner) BasePath() string {
+	return "/u/" + o.UserName
+}
+
+func WithOwner(ctx context.Context, o Owner) context.Context {
+	return context.WithValue(ctx, OWNER_KEY, &o)
+}
+
+// GetOwner returns the owner of the library being browsed or nil outside
+// of /u/<name>/ pages
+func GetOwner(ctx context.Context) *Owner {
+	o, ok := ctx.Value(OWNER_KEY).(*Owner)
+	if !ok {
+		return nil
+	}
+	return o
+}
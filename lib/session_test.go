@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	secret := []byte("s3cret")
+	s := NewSession(42, "anton", 3)
+
+	got, err := DecodeSession(secret, s.Encode(secret))
+	if err != nil {
+		t.Fatalf("DecodeSession: %v", err)
+	}
+
+	if got.UserID != 42 || got.UserName != "anton" || got.Expires != s.Expires || got.Version != 3 {
+		t.Errorf("DecodeSession = %+v, want %+v", got, s)
+	}
+}
+
+func TestDecodeSessionRejects(t *testing.T) {
+	secret := []byte("s3cret")
+	enc := base64.RawURLEncoding
+	valid := NewSession(42, "anton", 3).Encode(secret)
+	payload, mac, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"no signature", payload},
+		{"other secret", NewSession(42, "anton", 3).Encode([]byte("other"))},
+		{"tampered user", enc.EncodeToString([]byte("1|anton|9999999999|3")) + "." + mac},
+		{"truncated signature", payload + "." + mac[:len(mac)-2]},
+		{"expired", Session{UserID: 42, UserName: "anton", Expires: time.Now().Add(-time.Minute).Unix()}.Encode(secret)},
+		{"extra field", Session{UserID: 42, UserName: "anton|1", Expires: time.Now().Add(time.Hour).Unix()}.Encode(secret)},
+		{"missing version", enc.EncodeToString([]byte("42|anton|9999999999")) + "." + enc.EncodeToString(sign(secret, "42|anton|9999999999"))},
+		{"not base64", "!!!." + mac},
+	}
+
+	for _, tt := range tests {
+		if s, err := DecodeSession(secret, tt.value); err != ErrInvalidSession {
+			t.Errorf("%s: DecodeSession = %+v, %v, want ErrInvalidSession", tt.name, s, err)
+		}
+	}
+}
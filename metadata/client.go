@@ -0,0 +1,271 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const USER_AGENT = "bland/1.0 (+https://github.com/valueof/bland)"
+
+const TIMEOUT = 10 * time.Second
+const MAX_REDIRECTS = 5
+
+var ErrForbiddenAddress = errors.New("address not allowed")
+var ErrUnsupportedScheme = errors.New("only http and https urls are supported")
+var ErrTooManyRedirects = fmt.Errorf("stopped after %d redirects", MAX_REDIRECTS)
+
+// StatusError is returned when the server responds with anything but 200
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// allowlist holds the non-public networks that may be reached anyway
+var allowlist []*net.IPNet
+
+// SetAllowlist lets the fetcher reach non-public addresses in the given
+// CIDR ranges, e.g. 192.168.1.0/24
+func SetAllowlist(cidrs []string) error {
+	nets := []*net.IPNet{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, n)
+	}
+
+	allowlist = nets
+	return nil
+}
+
+// FORBIDDEN_NETWORKS are special purpose ranges the net.IP methods miss
+var FORBIDDEN_NETWORKS = parseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001:db8::/32",
+)
+
+func parseCIDRs(cidrs ...string) (nets []*net.IPNet) {
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// isForbidden reports whether ip isn't a public address
+func isForbidden(ip net.IP) bool {
+	// ::ffff:127.0.0.1 is 127.0.0.1
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, n := range allowlist {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	for _, n := range FORBIDDEN_NETWORKS {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified()
+}
+
+// checkAddress runs after the host name is resolved, right before connecting
+func checkAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || isForbidden(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+
+	return nil
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrUnsupportedScheme
+	}
+	return nil
+}
+
+var client = &http.Client{
+	Timeout: TIMEOUT,
+	Transport: &http.Transport{
+		// A proxy would be checked instead of the page
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: TIMEOUT,
+			Control: checkAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   TIMEOUT,
+		ResponseHeaderTimeout: TIMEOUT,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		// via includes the original request
+		if len(via) > MAX_REDIRECTS {
+			return ErrTooManyRedirects
+		}
+		return checkURL(req.URL)
+	},
+}
+
+// Response is a page fetched with Get
+type Response struct {
+	// URL is where the page ended up after redirects
+	URL         *url.URL
+	ContentType string
+	Body        []byte
+}
+
+// Get fetches a public http(s) URL and returns up to maxSize bytes of the
+// body. Anything past that is silently dropped.
+func Get(ctx context.Context, raw string, maxSize int64) (*Response, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		URL:         resp.Request.URL,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	}, nil
+}
+
+// Link is what Check found at a URL
+type Link struct {
+	StatusCode int
+
+	// URL is where redirects ended up
+	URL *url.URL
+}
+
+// Check requests a public http(s) URL with HEAD, or GET if that fails, to
+// see whether it still works. Error responses are returned as a Link.
+func Check(ctx context.Context, raw string) (*Link, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	link, err := request(ctx, "HEAD", u)
+	if err != nil || link.StatusCode < 400 {
+		return link, err
+	}
+
+	return request(ctx, "GET", u)
+}
+
+func request(ctx context.Context, method string, u *url.URL) (*Link, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return &Link{StatusCode: resp.StatusCode, URL: resp.Request.URL}, nil
+}
+
+// IsTemporary reports whether a failed fetch may work when tried again
+func IsTemporary(err error) bool {
+	if err == nil ||
+		errors.Is(err, ErrForbiddenAddress) ||
+		errors.Is(err, ErrUnsupportedScheme) ||
+		errors.Is(err, ErrTooManyRedirects) {
+		return false
+	}
+
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+
+	// Failed connections, lookups and timeouts
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,141 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsForbidden(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"100.128.0.1", false},
+		{"192.0.0.8", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:93.184.216.34", false},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b::a9fe:a9fe", true},
+	}
+
+	for _, tt := range tests {
+		if got := isForbidden(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isForbidden(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestIsForbiddenAllowlist(t *testing.T) {
+	if err := SetAllowlist([]string{"10.0.0.0/8", " "}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetAllowlist(nil)
+
+	if isForbidden(net.ParseIP("10.1.2.3")) || isForbidden(net.ParseIP("::ffff:10.1.2.3")) {
+		t.Error("allowlisted address is forbidden")
+	}
+
+	if !isForbidden(net.ParseIP("192.168.1.1")) {
+		t.Error("address outside of the allowlist is allowed")
+	}
+
+	if err := SetAllowlist([]string{"not a network"}); err == nil {
+		t.Error("SetAllowlist accepted an invalid network")
+	}
+}
+
+func TestGetRefusesLocalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<title>local</title>")
+	}))
+	defer srv.Close()
+
+	_, err := Get(context.Background(), srv.URL, 1024)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("Get(%s) = %v, want ErrForbiddenAddress", srv.URL, err)
+	}
+
+	if IsTemporary(err) {
+		t.Error("forbidden address is reported as temporary")
+	}
+
+	SetAllowlist([]string{"127.0.0.0/8"})
+	defer SetAllowlist(nil)
+
+	resp, err := Get(context.Background(), srv.URL, 1024)
+	if err != nil {
+		t.Fatalf("Get(%s) with allowlist: %v", srv.URL, err)
+	}
+
+	if string(resp.Body) != "<title>local</title>\n" {
+		t.Errorf("Body = %q", resp.Body)
+	}
+}
+
+func TestGetRedirects(t *testing.T) {
+	SetAllowlist([]string{"127.0.0.0/8"})
+	defer SetAllowlist(nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/hops/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(r.URL.Path, "/hops/%d", &n)
+		if n == 0 {
+			fmt.Fprint(w, "done")
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hops/%d", n-1), http.StatusFound)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := Get(context.Background(), fmt.Sprintf("%s/hops/%d", srv.URL, MAX_REDIRECTS), 1024)
+	if err != nil {
+		t.Fatalf("%d redirects: %v", MAX_REDIRECTS, err)
+	}
+
+	if resp.URL.Path != "/hops/0" {
+		t.Errorf("URL = %s, want it to end at /hops/0", resp.URL)
+	}
+
+	_, err = Get(context.Background(), srv.URL+"/loop", 1024)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("redirect loop: got %v, want ErrTooManyRedirects", err)
+	}
+
+	_, err = Get(context.Background(), srv.URL+"/file", 1024)
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("redirect to file: got %v, want ErrUnsupportedScheme", err)
+	}
+}
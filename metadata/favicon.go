@@ -0,0 +1,134 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// MAX_ICON_SIZE is the largest icon that is kept
+const MAX_ICON_SIZE = 100 << 10
+
+var ErrNoIcon = errors.New("no usable icon found")
+
+// ICON_TYPES are the sniffed image types icons are kept as. SVG can carry
+// scripts so it isn't one of them.
+var ICON_TYPES = map[string]bool{
+	"image/x-icon": true,
+	"image/png":    true,
+	"image/gif":    true,
+	"image/jpeg":   true,
+	"image/webp":   true,
+	"image/bmp":    true,
+}
+
+type Icon struct {
+	ContentType string
+	Data        []byte
+}
+
+// FetchIcon finds the icon of a site by looking at the <link> elements of
+// its home page and falling back to /favicon.ico
+func FetchIcon(ctx context.Context, host string) (*Icon, error) {
+	candidates := []string{}
+	for _, scheme := range []string{"https", "http"} {
+		home := &url.URL{Scheme: scheme, Host: host, Path: "/"}
+		links, err := iconLinks(ctx, home.String())
+		if err != nil {
+			if errors.Is(err, ErrForbiddenAddress) {
+				return nil, err
+			}
+			continue
+		}
+
+		candidates = append(links, resolve(home, "/favicon.ico"))
+		break
+	}
+
+	if len(candidates) == 0 {
+		candidates = []string{
+			(&url.URL{Scheme: "https", Host: host, Path: "/favicon.ico"}).String(),
+			(&url.URL{Scheme: "http", Host: host, Path: "/favicon.ico"}).String(),
+		}
+	}
+
+	for _, c := range dedupe(candidates) {
+		if icon, err := getIcon(ctx, c); err == nil {
+			return icon, nil
+		}
+	}
+
+	return nil, ErrNoIcon
+}
+
+// iconLinks returns the icons a page links to, apple-touch-icon last
+func iconLinks(ctx context.Context, raw string) ([]string, error) {
+	resp, err := Get(ctx, raw, MAX_HTML_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := charset.NewReader(bytes.NewReader(resp.Body), resp.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	icons := []string{}
+	touch := []string{}
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "link" && !strings.Contains(strings.ToLower(attr(n, "type")), "svg") {
+			href := resolve(resp.URL, attr(n, "href"))
+			for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+				if href == "" {
+					break
+				}
+				if rel == "icon" {
+					icons = append(icons, href)
+					break
+				}
+				if rel == "apple-touch-icon" || rel == "apple-touch-icon-precomposed" {
+					touch = append(touch, href)
+					break
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(doc)
+	return append(icons, touch...), nil
+}
+
+func getIcon(ctx context.Context, raw string) (*Icon, error) {
+	resp, err := Get(ctx, raw, MAX_ICON_SIZE+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Body) == 0 || len(resp.Body) > MAX_ICON_SIZE {
+		return nil, ErrNoIcon
+	}
+
+	contentType := http.DetectContentType(resp.Body)
+	if !ICON_TYPES[contentType] {
+		return nil, ErrNoIcon
+	}
+
+	return &Icon{ContentType: contentType, Data: resp.Body}, nil
+}
@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// linkedData is the part of schema.org JSON-LD we care about. Fields can
+// be a value or a list, so they're decoded loosely.
+type linkedData struct {
+	Type          any             `json:"@type"`
+	Graph         []linkedData    `json:"@graph"`
+	Headline      string          `json:"headline"`
+	Description   string          `json:"description"`
+	DatePublished string          `json:"datePublished"`
+	Author        json.RawMessage `json:"author"`
+	Keywords      json.RawMessage `json:"keywords"`
+	Publisher     json.RawMessage `json:"publisher"`
+}
+
+// parseLinkedData returns every node in a JSON-LD script
+func parseLinkedData(script string) (nodes []linkedData) {
+	script = strings.TrimSpace(script)
+
+	var list []linkedData
+	if err := json.Unmarshal([]byte(script), &list); err != nil {
+		var node linkedData
+		if err := json.Unmarshal([]byte(script), &node); err != nil {
+			return nil
+		}
+		list = []linkedData{node}
+	}
+
+	for _, n := range list {
+		nodes = append(nodes, n)
+		nodes = append(nodes, n.Graph...)
+	}
+
+	return nodes
+}
+
+// isArticle reports whether the node describes the page itself
+func (n linkedData) isArticle() bool {
+	types := []string{}
+	switch t := n.Type.(type) {
+	case string:
+		types = append(types, t)
+	case []any:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+
+	for _, t := range types {
+		if strings.HasSuffix(t, "Article") || strings.HasSuffix(t, "Posting") ||
+			t == "WebPage" || t == "Report" || t == "Review" || t == "Recipe" {
+			return true
+		}
+	}
+	return false
+}
+
+// names decodes a person or organization, or a list of them
+func names(raw json.RawMessage) (out []string) {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return []string{name}
+	}
+
+	var thing struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &thing); err == nil {
+		if thing.Name == "" {
+			return nil
+		}
+		return []string{thing.Name}
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		for _, item := range list {
+			out = append(out, names(item)...)
+		}
+	}
+
+	return out
+}
+
+// keywords decodes either a comma separated string or a list of strings
+func keywords(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.Split(s, ",")
+	}
+
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+// Package metadata fetches bookmarked pages and finds out what they're about
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// MAX_HTML_SIZE is how much of a page is read
+const MAX_HTML_SIZE = 2 << 20
+
+// MAX_SUGGESTED_TAGS caps how many keywords are turned into tags
+const MAX_SUGGESTED_TAGS = 10
+
+var ErrNotHTML = errors.New("not an HTML page")
+
+type Metadata struct {
+	// URL is the canonical URL, or where redirects ended up
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	SiteName    string   `json:"siteName"`
+	Authors     []string `json:"authors"`
+	Keywords    []string `json:"keywords"`
+
+	// PublishedAt is a unix timestamp, 0 if the page doesn't say
+	PublishedAt int64 `json:"publishedAt"`
+
+	// Tags are by: tags for the authors followed by the keywords
+	Tags []string `json:"tags"`
+}
+
+var SPACE_RE *regexp.Regexp = regexp.MustCompile(`\s+`)
+
+// DATE_FORMATS are the formats publish dates are commonly written in
+var DATE_FORMATS = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Fetch downloads the page at raw and extracts its metadata
+func Fetch(ctx context.Context, raw string) (*Metadata, error) {
+	doc, base, err := fetchDocument(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(doc, base), nil
+}
+
+// fetchDocument downloads and parses a page and returns where it ended up
+func fetchDocument(ctx context.Context, raw string) (*html.Node, *url.URL, error) {
+	resp, err := Get(ctx, raw, MAX_HTML_SIZE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.ContentType != "" {
+		mediatype, _, err := mime.ParseMediaType(resp.ContentType)
+		if err != nil || (mediatype != "text/html" && mediatype != "application/xhtml+xml") {
+			return nil, nil, ErrNotHTML
+		}
+	}
+
+	// Converts the page to UTF-8
+	r, err := charset.NewReader(bytes.NewReader(resp.Body), resp.ContentType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return doc, resp.URL, nil
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, name string) bool {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func text(n *html.Node) string {
+	s := ""
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			s += c.Data
+		}
+	}
+	return s
+}
+
+// page holds everything a document says about itself, per source
+type page struct {
+	title        string
+	meta         map[string][]string
+	canonical    string
+	linkedData   []linkedData
+	seenTitleTag bool
+}
+
+func (p *page) first(names ...string) string {
+	for _, name := range names {
+		for _, v := range p.meta[name] {
+			if v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+func (p *page) all(names ...string) (values []string) {
+	for _, name := range names {
+		values = append(values, p.meta[name]...)
+	}
+	return values
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Parse extracts metadata from a parsed page located at base, preferring
+// Open Graph and Twitter tags, then <title> and <meta>, then JSON-LD
+func Parse(doc *html.Node, base *url.URL) *Metadata {
+	p := &page{meta: map[string][]string{}}
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "meta":
+				name := strings.ToLower(firstOf(attr(n, "property"), attr(n, "name"), attr(n, "itemprop")))
+				if name != "" {
+					p.meta[name] = append(p.meta[name], attr(n, "content"))
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+					if rel == "canonical" && p.canonical == "" {
+						p.canonical = attr(n, "href")
+					}
+				}
+			case "title":
+				// <title> can also show up inside <svg>
+				if !p.seenTitleTag {
+					p.title = text(n)
+					p.seenTitleTag = true
+				}
+			case "script":
+				if strings.EqualFold(attr(n, "type"), "application/ld+json") {
+					p.linkedData = append(p.linkedData, parseLinkedData(text(n))...)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(doc)
+
+	var article linkedData
+	var publisher []string
+	for _, n := range p.linkedData {
+		if n.isArticle() && article.Type == nil {
+			article = n
+		}
+		if len(publisher) == 0 {
+			publisher = names(n.Publisher)
+		}
+	}
+
+	m := &Metadata{}
+	m.SiteName = clean(firstOf(p.first("og:site_name", "application-name"), firstItem(publisher)))
+	m.Title = clean(firstOf(p.first("og:title", "twitter:title"), article.Headline, p.title))
+	m.Title = stripSiteName(m.Title, m.SiteName)
+	m.Description = clean(firstOf(p.first("og:description", "twitter:description", "description"), article.Description))
+
+	m.URL = base.String()
+	if u := resolve(base, firstOf(p.canonical, p.first("og:url"))); u != "" {
+		m.URL = u
+	}
+
+	authors := []string{}
+	for _, a := range p.all("author", "article:author", "citation_author") {
+		// article:author is supposed to be a link to a profile
+		if u, err := url.Parse(a); err == nil && u.IsAbs() {
+			continue
+		}
+		authors = append(authors, a)
+	}
+	authors = append(authors, names(article.Author)...)
+	m.Authors = dedupe(authors)
+
+	published := firstOf(p.first("article:published_time", "datepublished", "citation_publication_date", "date"), article.DatePublished)
+	m.PublishedAt = parseDate(published)
+
+	kws := []string{}
+	for _, k := range p.all("keywords", "news_keywords") {
+		kws = append(kws, strings.Split(k, ",")...)
+	}
+	kws = append(kws, p.all("article:tag")...)
+	kws = append(kws, keywords(article.Keywords)...)
+	m.Keywords = dedupe(kws)
+
+	m.Tags = suggestTags(m.Authors, m.Keywords)
+	return m
+}
+
+func clean(s string) string {
+	return strings.TrimSpace(SPACE_RE.ReplaceAllString(s, " "))
+}
+
+func firstItem(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
+
+// dedupe cleans up values and drops empty and repeated ones, ignoring case
+func dedupe(values []string) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		v = clean(v)
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// resolve returns ref as an absolute http(s) URL, or ""
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+func parseDate(s string) int64 {
+	s = strings.TrimSpace(s)
+	for _, layout := range DATE_FORMATS {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Unix()
+		}
+	}
+	return 0
+}
+
+// TITLE_SEPARATORS separate the title from the site name, e.g. "Article | Site"
+var TITLE_SEPARATORS = []string{" | ", " - ", " – ", " — ", " · ", " • ", " :: ", ": "}
+
+// stripSiteName removes the site name from the beginning or end of title
+func stripSiteName(title, site string) string {
+	if site == "" || strings.EqualFold(title, site) {
+		return title
+	}
+
+	// Lowercasing may change the length of the title, so compare parts
+	for _, sep := range TITLE_SEPARATORS {
+		parts := strings.Split(title, sep)
+		for i := len(parts) - 1; i > 0; i-- {
+			if strings.EqualFold(strings.Join(parts[i:], sep), site) {
+				return strings.TrimSpace(strings.Join(parts[:i], sep))
+			}
+		}
+		for i := 1; i < len(parts); i++ {
+			if strings.EqualFold(strings.Join(parts[:i], sep), site) {
+				return strings.TrimSpace(strings.Join(parts[i:], sep))
+			}
+		}
+	}
+
+	return title
+}
+
+// Tag turns a name or keyword into a tag, e.g. "Rob Pike" into "rob-pike"
+func Tag(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '+' || r == '.' || r == '_':
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return strings.Trim(b.String(), ".")
+}
+
+func suggestTags(authors, keywords []string) []string {
+	tags := []string{}
+	for _, a := range authors {
+		if t := Tag(a); t != "" {
+			tags = append(tags, "by:"+t)
+		}
+	}
+
+	n := 0
+	for _, k := range keywords {
+		if t := Tag(k); t != "" && n < MAX_SUGGESTED_TAGS {
+			tags = append(tags, t)
+			n++
+		}
+	}
+
+	return dedupe(tags)
+}
@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+func TestStripSiteName(t *testing.T) {
+	tests := []struct {
+		title, site, want string
+	}{
+		{"Go Concurrency Patterns | The Go Blog", "The Go Blog", "Go Concurrency Patterns"},
+		{"The Go Blog - Go Concurrency Patterns", "The Go Blog", "Go Concurrency Patterns"},
+		{"Go Concurrency Patterns — the go blog", "The Go Blog", "Go Concurrency Patterns"},
+		{"Go Concurrency Patterns", "The Go Blog", "Go Concurrency Patterns"},
+		{"The Go Blog", "the go blog", "The Go Blog"},
+		{"Pipelines | Go | Blog", "Go | Blog", "Pipelines"},
+		{"Pipelines", "", "Pipelines"},
+
+		// Lowercasing changes how many bytes these take
+		{"x | ȺȺȺ", "ȺȺȺ", "x"},
+		{"ȺȺȺ | x", "ȺȺȺ", "x"},
+		{"x | İstanbul", "İstanbul", "x"},
+		{"İstanbul: x", "İSTANBUL", "x"},
+		{"Ⱥx | ȺȺȺ", "x", "Ⱥx | ȺȺȺ"},
+	}
+
+	for _, tt := range tests {
+		if got := stripSiteName(tt.title, tt.site); got != tt.want {
+			t.Errorf("stripSiteName(%q, %q) = %q, want %q", tt.title, tt.site, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	published := time.Date(2022, 10, 17, 9, 30, 0, 0, time.UTC).Unix()
+
+	tests := []struct {
+		name string
+		page string
+		want Metadata
+	}{
+		{
+			"plain tags",
+			`<html><head>
+				<title>  Go Concurrency
+				Patterns | The Go Blog </title>
+				<meta name="description" content="Pipelines and cancellation">
+				<meta name="application-name" content="The Go Blog">
+				<link rel="canonical" href="/blog/pipelines">
+				<meta name="author" content="Sameer Ajmani">
+				<meta name="keywords" content="Go, concurrency,,Go">
+			</head></html>`,
+			Metadata{
+				URL:         "https://go.dev/blog/pipelines",
+				Title:       "Go Concurrency Patterns",
+				Description: "Pipelines and cancellation",
+				SiteName:    "The Go Blog",
+				Authors:     []string{"Sameer Ajmani"},
+				Keywords:    []string{"Go", "concurrency"},
+				Tags:        []string{"by:sameer-ajmani", "go", "concurrency"},
+			},
+		},
+		{
+			"open graph over title",
+			`<html><head>
+				<title>Ignored</title>
+				<meta property="og:title" content="Pipelines">
+				<meta property="og:description" content="From Open Graph">
+				<meta name="description" content="Ignored">
+				<meta property="og:url" content="https://go.dev/blog/pipelines">
+				<meta property="article:author" content="https://go.dev/authors/sameer">
+				<meta property="article:published_time" content="2022-10-17T09:30:00Z">
+				<meta property="article:tag" content="Go">
+			</head><body><svg><title>Icon</title></svg></body></html>`,
+			Metadata{
+				URL:         "https://go.dev/blog/pipelines",
+				Title:       "Pipelines",
+				Description: "From Open Graph",
+				PublishedAt: published,
+				Keywords:    []string{"Go"},
+				Tags:        []string{"go"},
+			},
+		},
+		{
+			"json-ld",
+			`<html><head>
+				<title>Fallback</title>
+				<script type="application/ld+json">{"@graph": [
+					{"@type": "Organization", "name": "Not the article"},
+					{"@type": ["NewsArticle"], "headline": "Pipelines",
+					 "description": "From JSON-LD", "datePublished": "2022-10-17T09:30:00Z",
+					 "author": [{"@type": "Person", "name": "Sameer Ajmani"}, "Rob Pike"],
+					 "publisher": {"name": "The Go Blog"},
+					 "keywords": "go, channels"}
+				]}</script>
+				<script type="application/ld+json">not json</script>
+			</head></html>`,
+			Metadata{
+				URL:         "https://go.dev/blog/",
+				Title:       "Pipelines",
+				Description: "From JSON-LD",
+				SiteName:    "The Go Blog",
+				Authors:     []string{"Sameer Ajmani", "Rob Pike"},
+				Keywords:    []string{"go", "channels"},
+				PublishedAt: published,
+				Tags:        []string{"by:sameer-ajmani", "by:rob-pike", "go", "channels"},
+			},
+		},
+		{
+			"site name that lowercases to more bytes",
+			`<title>x | ȺȺȺ</title><meta property="og:site_name" content="ȺȺȺ">`,
+			Metadata{URL: "https://go.dev/blog/", Title: "x", SiteName: "ȺȺȺ"},
+		},
+		{
+			"empty page",
+			``,
+			Metadata{URL: "https://go.dev/blog/"},
+		},
+	}
+
+	base, _ := url.Parse("https://go.dev/blog/")
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(tt.page))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		got := Parse(doc, base)
+		check := func(field, got, want string) {
+			if got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, field, got, want)
+			}
+		}
+
+		check("URL", got.URL, tt.want.URL)
+		check("Title", got.Title, tt.want.Title)
+		check("Description", got.Description, tt.want.Description)
+		check("SiteName", got.SiteName, tt.want.SiteName)
+		check("Authors", strings.Join(got.Authors, ", "), strings.Join(tt.want.Authors, ", "))
+		check("Keywords", strings.Join(got.Keywords, ", "), strings.Join(tt.want.Keywords, ", "))
+		check("Tags", strings.Join(got.Tags, ", "), strings.Join(tt.want.Tags, ", "))
+		if got.PublishedAt != tt.want.PublishedAt {
+			t.Errorf("%s: PublishedAt = %d, want %d", tt.name, got.PublishedAt, tt.want.PublishedAt)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MIN_PARAGRAPH_LENGTH is how many characters a paragraph needs to count
+// towards the score of the element it's in
+const MIN_PARAGRAPH_LENGTH = 25
+
+// MIN_ARTICLE_WORDS is the fewest words a page needs to be an article
+const MIN_ARTICLE_WORDS = 50
+
+var ErrNoArticle = errors.New("no article text found")
+
+// Elements whose class or id match UNLIKELY_RE but not MAYBE_RE are pruned
+var UNLIKELY_RE *regexp.Regexp = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|disqus|footer|header|menu|modal|nav|newsletter|pager|popup|promo|related|replies|share|sidebar|skyscraper|social|sponsor|subscribe|toolbar|widget|advert`)
+var MAYBE_RE *regexp.Regexp = regexp.MustCompile(`(?i)article|body|column|content|main|post|story`)
+
+// POSITIVE_RE and NEGATIVE_RE adjust scores by class and id
+var POSITIVE_RE *regexp.Regexp = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+var NEGATIVE_RE *regexp.Regexp = regexp.MustCompile(`(?i)hidden|comment|footer|footnote|masthead|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|widget`)
+
+var EMPTY_PARAGRAPH_RE *regexp.Regexp = regexp.MustCompile(`<p>\s*</p>`)
+var BLANK_LINES_RE *regexp.Regexp = regexp.MustCompile(`[ \t]*\n[\s]*\n\s*`)
+
+// PRUNED_ELEMENTS never contain any of the article
+var PRUNED_ELEMENTS = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Button:   true,
+	atom.Input:    true,
+	atom.Select:   true,
+	atom.Textarea: true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Svg:      true,
+	atom.Canvas:   true,
+	atom.Video:    true,
+	atom.Audio:    true,
+	atom.Img:      true,
+	atom.Picture:  true,
+}
+
+// BLOCK_ELEMENTS keep a <div> from being scored as a paragraph itself
+var BLOCK_ELEMENTS = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.Div:        true,
+	atom.Section:    true,
+	atom.Article:    true,
+	atom.Table:      true,
+	atom.Ul:         true,
+	atom.Ol:         true,
+	atom.Dl:         true,
+	atom.Blockquote: true,
+	atom.Pre:        true,
+	atom.H1:         true,
+	atom.H2:         true,
+	atom.H3:         true,
+	atom.H4:         true,
+	atom.H5:         true,
+	atom.H6:         true,
+}
+
+// ARTICLE_ELEMENTS are the elements kept in the article and what they're
+// written as, everything else is replaced by its contents
+var ARTICLE_ELEMENTS = map[atom.Atom]string{
+	atom.P:          "p",
+	atom.H1:         "h2",
+	atom.H2:         "h2",
+	atom.H3:         "h3",
+	atom.H4:         "h4",
+	atom.H5:         "h5",
+	atom.H6:         "h6",
+	atom.Ul:         "ul",
+	atom.Ol:         "ol",
+	atom.Li:         "li",
+	atom.Dl:         "dl",
+	atom.Dt:         "dt",
+	atom.Dd:         "dd",
+	atom.Blockquote: "blockquote",
+	atom.Pre:        "pre",
+	atom.Code:       "code",
+	atom.Em:         "em",
+	atom.I:          "em",
+	atom.Strong:     "strong",
+	atom.B:          "strong",
+	atom.Sub:        "sub",
+	atom.Sup:        "sup",
+	atom.A:          "a",
+	atom.Br:         "br",
+	atom.Hr:         "hr",
+	atom.Figcaption: "p",
+}
+
+// Article is the readable part of a page
+type Article struct {
+	// Content is simplified, escaped HTML that can be shown as is
+	Content   string
+	Text      string
+	WordCount int
+}
+
+// FetchArticle downloads the page at raw and extracts its main text
+func FetchArticle(ctx context.Context, raw string) (*Article, error) {
+	doc, base, err := fetchDocument(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	a := ExtractArticle(doc, base)
+	if a == nil {
+		return nil, ErrNoArticle
+	}
+	return a, nil
+}
+
+// ExtractArticle finds the main text of a page the way Readability does,
+// or returns nil. doc is modified along the way.
+func ExtractArticle(doc *html.Node, base *url.URL) *Article {
+	prune(doc)
+
+	scores := map[*html.Node]float64{}
+	add := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+		}
+		scores[n] += score
+	}
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if isParagraph(n) {
+			if t := strings.TrimSpace(innerText(n)); len(t) >= MIN_PARAGRAPH_LENGTH {
+				score := 1 + float64(strings.Count(t, ",")) + math.Min(float64(len(t))/100, 3)
+				add(n.Parent, score)
+				if n.Parent != nil {
+					add(n.Parent.Parent, score/2)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	var top *html.Node
+	best := 0.0
+	for n, score := range scores {
+		scores[n] = score * (1 - linkDensity(n))
+		if scores[n] > best {
+			top, best = n, scores[n]
+		}
+	}
+
+	if top == nil {
+		return nil
+	}
+
+	// Take along siblings, like an intro outside of the body
+	parts := []*html.Node{top}
+	if top.Parent != nil && top.DataAtom != atom.Body {
+		parts = nil
+		threshold := math.Max(10, best*0.2)
+		for s := top.Parent.FirstChild; s != nil; s = s.NextSibling {
+			if s.Type != html.ElementNode {
+				continue
+			}
+
+			include := s == top || scores[s] >= threshold
+			if s.DataAtom == atom.P {
+				t := innerText(s)
+				include = include || (len(t) > 80 && linkDensity(s) < 0.25)
+			}
+
+			if include {
+				parts = append(parts, s)
+			}
+		}
+	}
+
+	w := &articleWriter{base: base}
+	for _, n := range parts {
+		w.write(n)
+	}
+
+	a := &Article{
+		Content: strings.TrimSpace(EMPTY_PARAGRAPH_RE.ReplaceAllString(w.html.String(), "")),
+		Text:    strings.TrimSpace(BLANK_LINES_RE.ReplaceAllString(w.text.String(), "\n\n")),
+	}
+	a.WordCount = len(strings.Fields(a.Text))
+
+	if a.WordCount < MIN_ARTICLE_WORDS {
+		return nil
+	}
+	return a
+}
+
+func classAndID(n *html.Node) string {
+	return attr(n, "class") + " " + attr(n, "id")
+}
+
+// prune removes everything that can't be part of the article
+func prune(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		if c.Type == html.CommentNode {
+			n.RemoveChild(c)
+		} else if c.Type == html.ElementNode && isPruned(c) {
+			n.RemoveChild(c)
+		} else {
+			prune(c)
+		}
+
+		c = next
+	}
+}
+
+func isPruned(n *html.Node) bool {
+	if PRUNED_ELEMENTS[n.DataAtom] {
+		return true
+	}
+
+	if hasAttr(n, "hidden") || strings.EqualFold(attr(n, "aria-hidden"), "true") {
+		return true
+	}
+
+	style := strings.ReplaceAll(strings.ToLower(attr(n, "style")), " ", "")
+	if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+		return true
+	}
+
+	switch n.DataAtom {
+	case atom.Html, atom.Body, atom.Article, atom.Main, atom.A:
+		return false
+	}
+
+	s := classAndID(n)
+	return UNLIKELY_RE.MatchString(s) && !MAYBE_RE.MatchString(s)
+}
+
+// isParagraph reports whether n is scored as a paragraph
+func isParagraph(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+
+	switch n.DataAtom {
+	case atom.P, atom.Pre, atom.Td:
+		return true
+	case atom.Div:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && BLOCK_ELEMENTS[c.DataAtom] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func initialScore(n *html.Node) (score float64) {
+	switch n.DataAtom {
+	case atom.Div, atom.Article, atom.Main, atom.Section:
+		score = 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		score = 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li:
+		score = -3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		score = -5
+	}
+
+	for _, s := range []string{attr(n, "class"), attr(n, "id")} {
+		if s == "" {
+			continue
+		}
+		if NEGATIVE_RE.MatchString(s) {
+			score -= 25
+		}
+		if POSITIVE_RE.MatchString(s) {
+			score += 25
+		}
+	}
+
+	return score
+}
+
+// innerText returns all the text inside n
+func innerText(n *html.Node) string {
+	var b strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return b.String()
+}
+
+// linkDensity is the share of the text inside n that is link text
+func linkDensity(n *html.Node) float64 {
+	total := len(innerText(n))
+	if total == 0 {
+		return 0
+	}
+
+	links := 0
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			links += len(innerText(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+
+	return float64(links) / float64(total)
+}
+
+// articleWriter writes the article as simplified HTML and plain text
+type articleWriter struct {
+	base *url.URL
+	html strings.Builder
+	text strings.Builder
+	pre  int
+}
+
+func (w *articleWriter) write(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		s := n.Data
+		if w.pre == 0 {
+			s = SPACE_RE.ReplaceAllString(s, " ")
+		}
+		w.html.WriteString(html.EscapeString(s))
+		w.text.WriteString(s)
+		return
+
+	case html.ElementNode:
+
+	default:
+		return
+	}
+
+	tag, ok := ARTICLE_ELEMENTS[n.DataAtom]
+	if tag == "a" {
+		href := resolve(w.base, attr(n, "href"))
+		ok = href != ""
+		if ok {
+			w.html.WriteString(`<a href="` + html.EscapeString(href) + `">`)
+		}
+	} else if ok {
+		w.html.WriteString("<" + tag + ">")
+	}
+
+	switch tag {
+	case "br":
+		w.text.WriteString("\n")
+		return
+	case "hr":
+		w.text.WriteString("\n\n")
+		return
+	case "pre":
+		w.pre++
+		defer func() { w.pre-- }()
+	}
+
+	block := BLOCK_ELEMENTS[n.DataAtom] || n.DataAtom == atom.Li || n.DataAtom == atom.Figcaption
+	if block {
+		w.text.WriteString("\n\n")
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.write(c)
+	}
+
+	if ok {
+		w.html.WriteString("</" + tag + ">")
+	}
+
+	if block {
+		w.text.WriteString("\n\n")
+	}
+}
@@ -0,0 +1,111 @@
+package metadata
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const ARTICLE_PAGE = `<!DOCTYPE html>
+<html>
+<head><title>Pipelines</title><script>var x = "script text";</script></head>
+<body>
+<header><h1>The Go Blog</h1></header>
+<nav><a href="/">Home</a> <a href="/blog/">Blog</a> <a href="/doc/">Documentation</a></nav>
+<div class="sidebar">Sidebar text that talks about other posts, at some length, with commas.</div>
+<div id="main">
+	<p>This intro sits outside of the post body and is long enough to be taken along with it.</p>
+	<div class="post">
+		<h1>Go Concurrency Patterns: Pipelines</h1>
+		<p>Go's concurrency primitives make it easy to construct streaming data pipelines, that make efficient use of I/O and multiple CPUs.</p>
+		<p>This article presents <b>examples</b> of such pipelines, highlights <i>subtleties</i> that arise when operations fail, and introduces <a href="../doc/effective_go">techniques</a> for dealing with failures cleanly.</p>
+		<p hidden>Hidden paragraph, which should never show up in the article.</p>
+		<p style="display: none">Another hidden paragraph, which should never show up either.</p>
+		<img src="gopher.png" alt="Gopher">
+		<p>There's no formal definition of a pipeline in Go; it's just one of many kinds of <a href="javascript:void(0)">concurrent programs</a> &lt;like this&gt;.</p>
+		<p></p>
+		<pre>func gen(nums ...int) {
+	for _, n := range nums {
+		out &lt;- n
+	}
+}</pre>
+	</div>
+	<div class="comments"><p>First comment, which goes on and on about something else entirely.</p></div>
+</div>
+<footer><p>Copyright notice and other footer text that nobody reads, ever.</p></footer>
+</body>
+</html>`
+
+func extract(t *testing.T, page string) *Article {
+	t.Helper()
+
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, _ := url.Parse("https://go.dev/blog/")
+	return ExtractArticle(doc, base)
+}
+
+func TestExtractArticle(t *testing.T) {
+	a := extract(t, ARTICLE_PAGE)
+	if a == nil {
+		t.Fatal("no article found")
+	}
+
+	for _, want := range []string{
+		"<p>This intro sits outside of the post body",
+		"<h2>Go Concurrency Patterns: Pipelines</h2>",
+		"<strong>examples</strong>",
+		"<em>subtleties</em>",
+		`<a href="https://go.dev/doc/effective_go">techniques</a>`,
+		"kinds of concurrent programs &lt;like this&gt;.</p>",
+		"<pre>func gen(nums ...int) {\n\tfor _, n := range nums {\n\t\tout &lt;- n\n\t}\n}</pre>",
+	} {
+		if !strings.Contains(a.Content, want) {
+			t.Errorf("content is missing %q:\n%s", want, a.Content)
+		}
+	}
+
+	for _, unwanted := range []string{
+		"script text", "The Go Blog", "Documentation", "Sidebar", "Hidden", "hidden paragraph",
+		"gopher.png", "javascript:", "First comment", "Copyright", "<p></p>", "<div",
+	} {
+		if strings.Contains(a.Content, unwanted) || strings.Contains(a.Text, unwanted) {
+			t.Errorf("article contains %q:\n%s", unwanted, a.Content)
+		}
+	}
+
+	if !strings.HasPrefix(a.Text, "This intro sits outside") || !strings.Contains(a.Text, "with it.\n\nGo Concurrency Patterns: Pipelines\n\nGo's concurrency") {
+		t.Errorf("text doesn't have a blank line between blocks:\n%s", a.Text)
+	}
+
+	if !strings.HasSuffix(a.Text, "\t\tout <- n\n\t}\n}") {
+		t.Errorf("text doesn't keep the whitespace in <pre>:\n%s", a.Text)
+	}
+
+	if a.WordCount != len(strings.Fields(a.Text)) {
+		t.Errorf("WordCount = %d, want %d", a.WordCount, len(strings.Fields(a.Text)))
+	}
+}
+
+func TestExtractArticleNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+	}{
+		{"empty", `<html><body></body></html>`},
+		{"too short", `<html><body><div><p>A single paragraph that isn't nearly long enough to be an article.</p></div></body></html>`},
+		{"only links", `<html><body><div>` + strings.Repeat(`<p><a href="/a">A list of links to other articles, one after the other</a></p>`, 20) + `</div></body></html>`},
+		{"only navigation", `<html><body><nav>` + strings.Repeat(`<p>Navigation text that is long enough to score, if it were kept.</p>`, 20) + `</nav></body></html>`},
+	}
+
+	for _, tt := range tests {
+		if a := extract(t, tt.page); a != nil {
+			t.Errorf("%s: got an article:\n%s", tt.name, a.Content)
+		}
+	}
+}
@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"embed"
 	"flag"
+	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/valueof/bland/data"
 	"github.com/valueof/bland/handlers"
+	"github.com/valueof/bland/jobs"
 	"github.com/valueof/bland/lib"
+	"github.com/valueof/bland/metadata"
 	s "github.com/valueof/bland/setup"
 )
 
@@ -21,13 +29,32 @@ var dev *bool
 var db *string
 var setup *bool
 var seed *string
+var seedHTML *string
+var rollback *int
+var purgeAfter *int
+var addUser *string
+var user *string
+var sessionKey *string
+var fetchAllow *string
+var workers *int
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
 
 func init() {
 	addr = flag.String("addr", "", "server address")
 	db = flag.String("db", "", "db file (required)")
 	dev = flag.Bool("dev", false, "dev environment (simplifies logging)")
-	setup = flag.Bool("setup", false, "create the db and exit")
+	setup = flag.Bool("setup", false, "create the db, apply migrations and exit")
 	seed = flag.String("seed", "", "import initial data from a json file")
+	seedHTML = flag.String("seed-html", "", "import initial data from a Netscape bookmark file (bookmarks.html)")
+	rollback = flag.Int("rollback", 0, "roll back the last N migrations and exit")
+	purgeAfter = flag.Int("purge-after", 0, "permanently delete bookmarks that have been in the trash for N days (0 keeps them forever)")
+	addUser = flag.String("add-user", "", "create a user (or reset their password) with the password read from stdin and exit")
+	user = flag.String("user", "", "user to import bookmarks for with -seed and -seed-html (defaults to the only user there is)")
+	sessionKey = flag.String("session-key", os.Getenv("BLAND_SESSION_KEY"), "secret used to sign session cookies (defaults to $BLAND_SESSION_KEY, random if empty)")
+	fetchAllow = flag.String("fetch-allow", "", "comma separated CIDR ranges of private addresses bland may fetch pages from, e.g. 192.168.1.0/24 (only public addresses by default)")
+	workers = flag.Int("workers", 2, "number of background jobs, like archiving pages, that run at the same time")
 }
 
 func tracing(uuid func() string) func(http.Handler) http.Handler {
@@ -68,12 +95,200 @@ func logging(logger *log.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// requiresLogin reports whether r may change data or see data that's only
+// for the owner: any request that isn't a GET or HEAD, the API, the pages
+// that only exist to submit forms, the trash, broken links, bookmark
+// history, settings and admin pages. The login page, the OpenAPI document
+// and the Pinboard API, which has its own tokens, are exempt.
+func requiresLogin(r *http.Request) bool {
+	p := r.URL.Path
+	if p == "/login" || p == "/api/openapi.json" || strings.HasPrefix(p, "/v1/") {
+		return false
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return true
+	}
+
+	return strings.HasPrefix(p, "/api/") ||
+		strings.HasPrefix(p, "/settings") ||
+		strings.HasPrefix(p, "/admin") ||
+		strings.HasPrefix(p, "/add") ||
+		strings.HasPrefix(p, "/edit") ||
+		strings.HasPrefix(p, "/trash") ||
+		strings.HasPrefix(p, "/broken") ||
+		strings.HasPrefix(p, "/history") ||
+		strings.HasSuffix(strings.TrimSuffix(p, "/"), "/manage")
+}
+
+// authentication reads the session cookie, or for /api/ routes the API
+// token from the Authorization: Bearer header, into the request context
+// and keeps anonymous visitors away from mutating routes. Pages redirect
+// to /login while everything else gets a 401.
+func authentication(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var session *lib.Session
+
+			auth := r.Header.Get("Authorization")
+			if auth != "" && strings.HasPrefix(r.URL.Path, "/api/") {
+				if !strings.HasPrefix(auth, "Bearer ") {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintln(w, "401 Unauthorized")
+					return
+				}
+
+				t, u, err := data.AuthenticateAPIToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintln(w, "401 Unauthorized")
+					return
+				}
+
+				session = &lib.Session{UserID: u.ID, UserName: u.Name, TokenID: t.ID, Scopes: t.Scopes}
+			} else if c, err := r.Cookie(lib.SESSION_COOKIE); err == nil {
+				s, err := lib.DecodeSession(secret, c.Value)
+				if err == nil {
+					// Make sure the user still exists and hasn't logged
+					// out or changed their password since
+					u, err := data.FetchUserByID(s.UserID)
+					if err == nil && u.SessionVersion == s.Version {
+						session = &s
+					}
+				}
+
+				if session == nil {
+					lib.ClearSessionCookie(w, r)
+				}
+			}
+
+			if session == nil && requiresLogin(r) {
+				if (r.Method == "GET" || r.Method == "HEAD") && !strings.HasPrefix(r.URL.Path, "/api/") {
+					http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+					return
+				}
+
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintln(w, "401 Unauthorized")
+				return
+			}
+
+			ctx := r.Context()
+			if session != nil {
+				ctx = context.WithValue(ctx, lib.SESSION_KEY, session)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// csrfProtection makes sure every browser has a CSRF nonce cookie, puts the
+// token derived from it into the request context for templates and rejects
+// mutating requests that don't send the token back, either in the
+// X-CSRF-Token header or the csrf_token form field. It has to run after
+// authentication since tokens are tied to the logged in user.
+func csrfProtection(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The Pinboard API authenticates every request with a token
+			// and doesn't use cookies, so there's nothing to forge
+			if strings.HasPrefix(r.URL.Path, "/v1/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			session := lib.GetSession(r.Context())
+
+			// Same for requests authenticated with an API token, browsers
+			// never add an Authorization header on their own
+			if session != nil && session.TokenID != 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var nonce string
+			if c, err := r.Cookie(lib.CSRF_COOKIE); err == nil {
+				nonce = c.Value
+			}
+
+			if r.Method != "GET" && r.Method != "HEAD" {
+				token := r.Header.Get(lib.CSRF_HEADER)
+				if token == "" {
+					token = r.PostFormValue(lib.CSRF_FIELD)
+				}
+
+				if !lib.ValidCSRFToken(secret, nonce, session, token) {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprintln(w, "403 Forbidden: invalid CSRF token")
+					return
+				}
+			}
+
+			if nonce == "" {
+				n, err := lib.NewCSRFNonce()
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				nonce = n
+				lib.SetCSRFCookie(w, r, nonce)
+			}
+
+			token := lib.CSRFToken(secret, nonce, session)
+			ctx := context.WithValue(r.Context(), lib.CSRF_KEY, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// purgeTrash periodically deletes bookmarks that have been in the trash
+// for longer than maxAge until ctx is cancelled
+func purgeTrash(ctx context.Context, logger *log.Logger, maxAge time.Duration) {
+	purge := func() {
+		tx, err := data.BeginTx(ctx)
+		if err != nil {
+			logger.Printf("purgeTrash: %v", err)
+			return
+		}
+
+		n, err := tx.PurgeDeletedBefore(time.Now().Add(-maxAge).Unix())
+		if err != nil {
+			logger.Printf("purgeTrash: %v", err)
+			tx.Rollback()
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			return
+		}
+
+		if n > 0 {
+			logger.Printf("purged %d bookmark(s) from the trash", n)
+		}
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func main() {
 	logger := log.New(os.Stdout, "", log.LstdFlags)
 
 	flag.Parse()
 
-	if *addr == "" && *seed == "" && !*setup {
+	if *addr == "" && *seed == "" && *seedHTML == "" && !*setup && *rollback == 0 && *addUser == "" {
 		flag.Usage()
 		return
 	}
@@ -83,15 +298,36 @@ func main() {
 		return
 	}
 
+	migrations, err := fs.Sub(sqlFiles, "sql")
+	if err != nil {
+		logger.Fatalf("could not read embedded migrations: %v", err)
+	}
+
+	if *rollback > 0 {
+		s.RollbackDB(*db, migrations, *rollback)
+		return
+	}
+
 	if *setup {
-		s.CreateDB(*db)
+		s.CreateDB(*db, migrations)
 	}
 
 	if *seed != "" {
-		s.FromPinboard(*db, *seed)
+		s.FromPinboard(*db, *seed, *user)
 	}
 
-	if *setup || *seed != "" {
+	if *seedHTML != "" {
+		s.FromNetscape(*db, *seedHTML, *user)
+	}
+
+	if *addUser != "" {
+		if !*setup {
+			s.CreateDB(*db, migrations)
+		}
+		s.AddUser(*db, *addUser, os.Stdin)
+	}
+
+	if *setup || *seed != "" || *seedHTML != "" || *addUser != "" {
 		return
 	}
 
@@ -101,15 +337,36 @@ func main() {
 		logger.Println("starting in PROD mode")
 	}
 
+	logger.Println("applying pending migrations")
+	err = s.MigrateDB(*db, migrations, logger.Printf)
+	if err != nil {
+		logger.Fatalf("could not migrate db: %v", err)
+	}
+
 	logger.Println("connecting to db")
-	err := data.ConnectToDB(*db)
+	err = data.ConnectToDB(*db)
 	if err != nil {
 		logger.Fatalf("could not connect to db: %v", err)
 	}
 	logger.Println("connected")
 
+	secret := []byte(*sessionKey)
+	if len(secret) == 0 {
+		logger.Println("no session key given, everyone will be logged out when the server restarts")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			logger.Fatalf("could not generate session key: %v", err)
+		}
+	}
+
+	if err := metadata.SetAllowlist(strings.Split(*fetchAllow, ",")); err != nil {
+		logger.Fatalf("invalid -fetch-allow: %v", err)
+	}
+
 	router := http.NewServeMux()
 	handlers.RegisterHandlers(router)
+	handlers.RegisterAuthHandlers(router, secret)
+	handlers.RegisterPinboardHandlers(router)
 
 	static := http.FileServer(http.Dir("static"))
 	router.Handle("/static/", http.StripPrefix("/static/", static))
@@ -119,9 +376,20 @@ func main() {
 		WriteTimeout: 5 * time.Second,
 		IdleTimeout:  15 * time.Second,
 		Addr:         *addr,
-		Handler:      tracing(uuid.NewString)(logging(logger)(router)),
+		Handler:      tracing(uuid.NewString)(logging(logger)(authentication(secret)(csrfProtection(secret)(router)))),
 	}
 
+	queue, err := jobs.Start(logger, *workers)
+	if err != nil {
+		logger.Fatalf("could not start the job queue: %v", err)
+	}
+
+	bg, stopBackground := context.WithCancel(context.Background())
+	if *purgeAfter > 0 {
+		go purgeTrash(bg, logger, time.Duration(*purgeAfter)*24*time.Hour)
+	}
+	go jobs.Schedule(bg, logger)
+
 	done := make(chan bool)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
@@ -129,6 +397,7 @@ func main() {
 	go func() {
 		<-quit
 		logger.Println("shutting down")
+		stopBackground()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -138,6 +407,11 @@ func main() {
 		if err := s.Shutdown(ctx); err != nil {
 			logger.Fatalf("could not gracefully shutdown: %v", err)
 		}
+
+		// Requests may have queued jobs up until now, whatever can't
+		// finish in time runs again after a restart
+		logger.Println("waiting for running jobs")
+		queue.Stop(ctx)
 		close(done)
 	}()
 
@@ -2,31 +2,105 @@ package setup
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/valueof/bland/data"
+	"github.com/valueof/bland/lib"
 )
 
+// PinboardSchema is a single post as it appears in Pinboard's JSON export
+// and in responses of the Pinboard v1 API. Note that Pinboard calls the
+// title "description" and the description "extended".
 type PinboardSchema struct {
-	HREF        string `json:"href"`
-	Description string `json:"description"`
-	Extended    string `json:"extended"`
-	Time        string `json:"time"`
-	ToRead      string `json:"toread"`
-	Tags        string `json:"tags"`
+	XMLName     xml.Name `json:"-" xml:"post"`
+	HREF        string   `json:"href" xml:"href,attr"`
+	Description string   `json:"description" xml:"description,attr"`
+	Extended    string   `json:"extended" xml:"extended,attr"`
+	Meta        string   `json:"meta" xml:"meta,attr"`
+	Hash        string   `json:"hash" xml:"hash,attr"`
+	Time        string   `json:"time" xml:"time,attr"`
+	Shared      string   `json:"shared" xml:"shared,attr"`
+	ToRead      string   `json:"toread" xml:"toread,attr"`
+	Tags        string   `json:"tags" xml:"tag,attr"`
 }
 
-func FromPinboard(dbp, fp string) {
+const PINBOARD_TIME = "2006-01-02T15:04:05Z"
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// PinboardFromBookmark converts a bookmark into a Pinboard post
+func PinboardFromBookmark(b data.Bookmark) PinboardSchema {
+	hash := md5.Sum([]byte(b.URL))
+	meta := md5.Sum([]byte(fmt.Sprintf("%s|%s|%s|%s|%d", b.URL, b.Title, b.Description, b.Tags, b.UpdatedAt)))
+
+	return PinboardSchema{
+		HREF:        b.URL,
+		Description: b.Title,
+		Extended:    b.Description,
+		Meta:        hex.EncodeToString(meta[:]),
+		Hash:        hex.EncodeToString(hash[:]),
+		Time:        time.Unix(b.CreatedAt, 0).UTC().Format(PINBOARD_TIME),
+		Shared:      yesNo(!b.IsPrivate),
+		ToRead:      yesNo(b.ToRead()),
+		Tags:        b.Tags,
+	}
+}
+
+// ToBookmark converts a Pinboard post into a bookmark. Posts that aren't
+// marked as toread are considered read at the time they were saved and
+// posts that aren't shared become private.
+func (d PinboardSchema) ToBookmark() data.Bookmark {
+	t, err := time.Parse(time.RFC3339, d.Time)
+	if err != nil {
+		if d.Time != "" {
+			fmt.Printf("couldn't parse time %s, will use now()\n", d.Time)
+		}
+		t = time.Now()
+	}
+
+	// Pinboard accepts tags separated by spaces or commas
+	tags := strings.Fields(strings.ReplaceAll(d.Tags, ",", " "))
+
+	b := data.Bookmark{
+		URL:         d.HREF,
+		Title:       d.Description,
+		Description: d.Extended,
+		Tags:        strings.Join(tags, " "),
+		CreatedAt:   t.Unix(),
+		UpdatedAt:   t.Unix(),
+		ReadAt:      0,
+		IsPrivate:   d.Shared == "no",
+	}
+
+	if d.ToRead != "yes" {
+		b.ReadAt = t.Unix()
+	}
+
+	return b
+}
+
+func FromPinboard(dbp, fp, user string) {
 	err := data.ConnectToDB(dbp)
 	if err != nil {
 		fmt.Printf("couldn't connect to db: %v\n", err)
 		os.Exit(1)
 	}
 
+	ctx := importContext(user)
+
 	f, err := os.Open(fp)
 	if err != nil {
 		fmt.Printf("couldn't read %s: %v\n", fp, err)
@@ -48,43 +122,74 @@ func FromPinboard(dbp, fp string) {
 	}
 
 	for _, d := range pins {
-		t, err := time.Parse(time.RFC3339, d.Time)
-		if err != nil {
-			fmt.Printf("couldn't parse time %s, will use now()\n", d.Time)
-			t = time.Now()
-		}
+		importBookmark(ctx, d.ToBookmark())
+	}
+}
 
-		b := data.Bookmark{
-			URL:         d.HREF,
-			Title:       d.Description,
-			Description: d.Extended,
-			Tags:        d.Tags,
-			CreatedAt:   t.Unix(),
-			UpdatedAt:   t.Unix(),
-			ReadAt:      0,
-		}
+// importContext returns the context imports for the given user should run
+// in. Without a user name bookmarks go to the only user there is or, if
+// there are no users yet, stay unowned until the first user is created.
+func importContext(name string) context.Context {
+	ctx := context.Background()
 
-		if d.ToRead == "no" {
-			b.ReadAt = t.Unix()
+	var user *data.User
+	if name != "" {
+		u, err := data.FetchUserByName(name)
+		if err != nil {
+			fmt.Printf("couldn't find user %s: %v\n", name, err)
+			os.Exit(1)
 		}
-
-		tx, err := data.BeginTx(context.Background())
+		user = u
+	} else {
+		users, err := data.FetchAllUsers()
 		if err != nil {
-			fmt.Printf("data.BeginTx: %v\n", err)
-			continue
+			fmt.Printf("couldn't fetch users: %v\n", err)
+			os.Exit(1)
 		}
 
-		_, err = tx.AddBookmark(b)
-		if err != nil {
-			if err := tx.Rollback(); err != nil {
-				fmt.Printf("tx.Rollback: %v\n", err)
-			}
-			continue
+		switch len(users) {
+		case 0:
+			return ctx
+		case 1:
+			user = &users[0]
+		default:
+			fmt.Println("there's more than one user, pick one with -user")
+			os.Exit(1)
 		}
+	}
 
-		err = tx.Commit()
-		if err != nil {
+	return lib.WithSession(ctx, lib.Session{UserID: user.ID, UserName: user.Name})
+}
+
+// importBookmark saves a single bookmark unless a bookmark with the same
+// URL already exists, so running an import twice doesn't create duplicates
+func importBookmark(ctx context.Context, b data.Bookmark) {
+	var userID int64
+	if s := lib.GetSession(ctx); s != nil {
+		userID = s.UserID
+	}
+
+	if _, err := data.FetchBookmarkByURL(data.OwnScope(userID), b.URL); err == nil {
+		fmt.Printf("skipping %s: already saved\n", b.URL)
+		return
+	}
+
+	tx, err := data.BeginTx(ctx)
+	if err != nil {
+		fmt.Printf("data.BeginTx: %v\n", err)
+		return
+	}
+
+	_, err = tx.AddBookmark(b)
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
 			fmt.Printf("tx.Rollback: %v\n", err)
 		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("tx.Rollback: %v\n", err)
 	}
 }
@@ -0,0 +1,204 @@
+package setup
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration is a pair of SQL scripts named like 005-something.up.sql and
+// 005-something.down.sql. The number is the version recorded in the
+// schema_migrations table once the up script has been applied.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var MIGRATION_RE *regexp.Regexp = regexp.MustCompile(`^(\d+)-(.+)\.(up|down)\.sql$`)
+
+// LoadMigrations reads all migrations from the root of fsys sorted by
+// version. Every migration must have an up script; down scripts are
+// optional but without one the migration can't be rolled back.
+func LoadMigrations(fsys fs.FS) (migrations []Migration, err error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		m := MIGRATION_RE.FindStringSubmatch(f.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(contents)
+		} else {
+			mig.Down = string(contents)
+		}
+	}
+
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %03d-%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d-%s", m.Version, m.Name)
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	create table if not exists schema_migrations (
+		version    integer primary key,
+		name       text,
+		applied_at integer
+	);
+	`)
+	return err
+}
+
+// AppliedVersions returns the set of migration versions recorded in the
+// schema_migrations table
+func AppliedVersions(db *sql.DB) (versions map[int]bool, err error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`select version from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions = map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = true
+	}
+
+	return versions, rows.Err()
+}
+
+// Migrate applies every migration that hasn't been applied yet, in order.
+// Each migration runs in its own transaction together with the insert into
+// schema_migrations so a failing script leaves no trace.
+func Migrate(db *sql.DB, migrations []Migration, logf func(string, ...any)) error {
+	applied, err := AppliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		logf("applying migration %s", m)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(m.Up); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %v", m, err)
+		}
+
+		q := `insert into schema_migrations (version, name, applied_at) values (?, ?, ?)`
+		if _, err := tx.Exec(q, m.Version, m.Name, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %v", m, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("%s: %v", m, err)
+		}
+	}
+
+	return nil
+}
+
+// Rollback reverts the last n applied migrations using their down scripts
+func Rollback(db *sql.DB, migrations []Migration, n int, logf func(string, ...any)) error {
+	applied, err := AppliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && n > 0; i-- {
+		m := migrations[i]
+		if !applied[m.Version] {
+			continue
+		}
+
+		if m.Down == "" {
+			return fmt.Errorf("%s can't be rolled back: no down script", m)
+		}
+
+		logf("rolling back migration %s", m)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(m.Down); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %v", m, err)
+		}
+
+		if _, err := tx.Exec(`delete from schema_migrations where version = ?`, m.Version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %v", m, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("%s: %v", m, err)
+		}
+
+		n--
+	}
+
+	return nil
+}
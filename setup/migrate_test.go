@@ -0,0 +1,127 @@
+package setup
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// tables returns the names of the tables in db other than the ones SQLite
+// and the full-text index manage themselves
+func tables(t *testing.T, db *sql.DB) (names []string) {
+	t.Helper()
+
+	rows, err := db.Query(`
+	select name from sqlite_master
+	where type = 'table' and name not like 'sqlite_%' and name not like 'bookmarks_fts_%'
+	order by name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(os.DirFS("../sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	logf := func(format string, args ...any) {}
+	if err := Migrate(db, migrations, logf); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			t.Skip("needs -tags sqlite_fts5")
+		}
+		t.Fatalf("up: %v", err)
+	}
+
+	applied, err := AppliedVersions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			t.Errorf("%s wasn't applied", m)
+		}
+	}
+	schema := strings.Join(tables(t, db), " ")
+
+	if err := Rollback(db, migrations, len(migrations), logf); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+
+	if got := strings.Join(tables(t, db), " "); got != "schema_migrations" {
+		t.Errorf("tables left after rolling back everything: %s", got)
+	}
+
+	// Every down script has to undo its up script well enough for it to
+	// run again
+	if err := Migrate(db, migrations, logf); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+
+	if got := strings.Join(tables(t, db), " "); got != schema {
+		t.Errorf("tables after migrating again = %s, want %s", got, schema)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002-tags.up.sql":       {Data: []byte("create table tags (id integer);")},
+		"002-tags.down.sql":     {Data: []byte("drop table tags;")},
+		"001-bookmarks.up.sql":  {Data: []byte("create table bookmarks (id integer);")},
+		"README.md":             {Data: []byte("not a migration")},
+		"010-no-down.up.sql":    {Data: []byte("select 1;")},
+		"003-orphan.down.sql":   {Data: []byte("select 1;")},
+		"004-renamed.up.sql":    {Data: []byte("select 1;")},
+		"004-other-name.up.sql": {Data: []byte("select 1;")},
+	}
+
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Error("LoadMigrations accepted a migration with two names")
+	}
+
+	delete(fsys, "004-other-name.up.sql")
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Error("LoadMigrations accepted a migration without an up script")
+	}
+
+	delete(fsys, "003-orphan.down.sql")
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, m := range migrations {
+		got = append(got, m.String())
+	}
+
+	if want := "001-bookmarks 002-tags 004-renamed 010-no-down"; strings.Join(got, " ") != want {
+		t.Errorf("LoadMigrations = %s, want %s", got, want)
+	}
+
+	if migrations[1].Down != "drop table tags;" || migrations[3].Down != "" {
+		t.Errorf("down scripts weren't matched up with their migrations")
+	}
+}
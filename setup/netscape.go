@@ -0,0 +1,186 @@
+package setup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valueof/bland/data"
+	"golang.org/x/net/html"
+)
+
+// NetscapeBookmark is a single <DT><A> entry from a Netscape Bookmark File,
+// the format browsers and most bookmarking services use for import and
+// export. The optional <DD> that follows an entry is its description.
+type NetscapeBookmark struct {
+	HREF         string
+	Title        string
+	Description  string
+	Tags         []string
+	Shortcut     string
+	AddDate      int64
+	LastModified int64
+	ToRead       bool
+	Private      bool
+}
+
+// parseTimestamp reads ADD_DATE and LAST_MODIFIED values. They're supposed
+// to be in seconds but some exporters use milli- or microseconds.
+func parseTimestamp(s string) int64 {
+	ts, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || ts <= 0 {
+		return 0
+	}
+
+	for ts > 1e11 {
+		ts /= 1000
+	}
+
+	return ts
+}
+
+// ParseNetscape reads all bookmarks from a Netscape Bookmark File. Folders
+// are flattened; only the links and their descriptions are kept.
+func ParseNetscape(r io.Reader) (bookmarks []NetscapeBookmark, err error) {
+	z := html.NewTokenizer(r)
+
+	var cur *NetscapeBookmark
+	inLink := false
+	inDesc := false
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return bookmarks, nil
+			}
+			return nil, z.Err()
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "a":
+				bookmarks = append(bookmarks, NetscapeBookmark{})
+				cur = &bookmarks[len(bookmarks)-1]
+				inLink = true
+				inDesc = false
+
+				for _, a := range t.Attr {
+					switch strings.ToLower(a.Key) {
+					case "href":
+						cur.HREF = strings.TrimSpace(a.Val)
+					case "add_date":
+						cur.AddDate = parseTimestamp(a.Val)
+					case "last_modified":
+						cur.LastModified = parseTimestamp(a.Val)
+					case "tags":
+						for _, tag := range strings.Split(a.Val, ",") {
+							tag = strings.Join(strings.Fields(tag), "-")
+							if tag != "" {
+								cur.Tags = append(cur.Tags, tag)
+							}
+						}
+					case "shortcuturl":
+						cur.Shortcut = strings.TrimSpace(a.Val)
+					case "toread":
+						cur.ToRead = a.Val == "1"
+					case "private":
+						cur.Private = a.Val == "1"
+					}
+				}
+			case "dd":
+				inDesc = cur != nil
+			case "dt", "dl", "h3":
+				inDesc = false
+			}
+
+		case html.EndTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "a":
+				inLink = false
+			case "dl", "dd":
+				inDesc = false
+			}
+
+		case html.TextToken:
+			if cur == nil {
+				continue
+			}
+
+			text := string(z.Text())
+			if inLink {
+				cur.Title += text
+			} else if inDesc {
+				cur.Description += text
+			}
+		}
+	}
+}
+
+// ToBookmark maps an entry onto data.Bookmark. Bookmarks that aren't
+// marked as TOREAD are considered read at the time they were added and
+// PRIVATE ones stay private.
+func (n NetscapeBookmark) ToBookmark() data.Bookmark {
+	created := n.AddDate
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+
+	updated := n.LastModified
+	if updated == 0 {
+		updated = created
+	}
+
+	b := data.Bookmark{
+		URL:         n.HREF,
+		Title:       strings.Join(strings.Fields(n.Title), " "),
+		Description: strings.TrimSpace(n.Description),
+		Shortcut:    n.Shortcut,
+		Tags:        strings.Join(n.Tags, " "),
+		CreatedAt:   created,
+		UpdatedAt:   updated,
+		ReadAt:      0,
+		IsPrivate:   n.Private,
+	}
+
+	if !n.ToRead {
+		b.ReadAt = created
+	}
+
+	return b
+}
+
+func FromNetscape(dbp, fp, user string) {
+	err := data.ConnectToDB(dbp)
+	if err != nil {
+		fmt.Printf("couldn't connect to db: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := importContext(user)
+
+	f, err := os.Open(fp)
+	if err != nil {
+		fmt.Printf("couldn't read %s: %v\n", fp, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	entries, err := ParseNetscape(f)
+	if err != nil {
+		fmt.Printf("couldn't parse %s: %v\n", fp, err)
+		os.Exit(1)
+	}
+
+	for _, n := range entries {
+		if n.HREF == "" || strings.HasPrefix(n.HREF, "place:") {
+			continue
+		}
+
+		importBookmark(ctx, n.ToBookmark())
+	}
+}
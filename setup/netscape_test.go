@@ -0,0 +1,54 @@
+package setup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNetscape(t *testing.T) {
+	// Roughly what a browser exports: nested folders, timestamps in
+	// milliseconds and entries without a description
+	file := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1667000000">Reading</H3>
+    <DD>Folder description
+    <DL><p>
+        <DT><A HREF=" https://go.dev/ " ADD_DATE="1667000000123" TAGS="go, two words,,">The   Go
+        Programming Language</A>
+        <DD>Docs &amp; blog
+        <DT><A HREF="https://example.com/" ADD_DATE="bogus" TOREAD="1" PRIVATE="1" SHORTCUTURL="ex">Example</A>
+    </DL><p>
+</DL><p>
+`
+
+	bookmarks, err := ParseNetscape(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 2 {
+		t.Fatalf("got %d bookmarks, want 2", len(bookmarks))
+	}
+
+	g := bookmarks[0]
+	if g.HREF != "https://go.dev/" || g.AddDate != 1667000000 || strings.Join(g.Tags, " ") != "go two-words" {
+		t.Errorf("first bookmark = %+v", g)
+	}
+
+	b := g.ToBookmark()
+	if b.Title != "The Go Programming Language" || b.Description != "Docs & blog" || b.ReadAt != 1667000000 || b.UpdatedAt != 1667000000 {
+		t.Errorf("first bookmark maps to %+v", b)
+	}
+
+	e := bookmarks[1]
+	if e.AddDate != 0 || !e.ToRead || !e.Private || e.Shortcut != "ex" || e.Description != "" {
+		t.Errorf("second bookmark = %+v", e)
+	}
+
+	if b := e.ToBookmark(); b.CreatedAt == 0 || b.ReadAt != 0 || !b.IsPrivate {
+		t.Errorf("second bookmark maps to %+v", b)
+	}
+}
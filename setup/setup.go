@@ -3,99 +3,77 @@ package setup
 import (
 	"database/sql"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
-	"sort"
-	"strings"
 )
 
-// CreateDB executes each file in the SQL directory (in order) to initialize
-// the complete database needed to run the server
-func CreateDB(fp string) {
-	db, err := sql.Open("sqlite3", fp)
+func openDB(fp string) (db *sql.DB, err error) {
+	db, err = sql.Open("sqlite3", fp)
 	if err != nil {
-		fmt.Printf("could not create or connect to db: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	err = db.Ping()
+	return db, nil
+}
+
+// MigrateDB brings the database up to date by applying every migration in
+// fsys that hasn't been applied yet. It's safe to call on every start.
+func MigrateDB(fp string, fsys fs.FS, logf func(string, ...any)) error {
+	migrations, err := LoadMigrations(fsys)
 	if err != nil {
-		fmt.Printf("could not create or connect to db: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("could not load migrations: %v", err)
 	}
 
-	files, err := os.ReadDir("sql")
+	db, err := openDB(fp)
 	if err != nil {
-		fmt.Printf("could not read files in ./sql: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("could not create or connect to db: %v", err)
 	}
+	defer db.Close()
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
+	return Migrate(db, migrations, logf)
+}
+
+// CreateDB creates the database, if it doesn't exist yet, and applies all
+// migrations needed to run the server
+func CreateDB(fp string, fsys fs.FS) {
+	err := MigrateDB(fp, fsys, func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
 	})
 
-	tx, err := db.Begin()
 	if err != nil {
-		fmt.Printf("db.Begin(): %v\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	for _, f := range files {
-		if f.IsDir() || !strings.HasSuffix(f.Name(), ".sql") {
-			continue
-		}
-
-		f, err := os.Open(filepath.Join("sql", f.Name()))
-		if err != nil {
-			fmt.Printf("could not read %s, database might be in the incomplete state", f.Name())
-			fmt.Println(err)
-
-			fmt.Println("rolling back")
-			err := tx.Rollback()
-			if err != nil {
-				fmt.Println(err)
-			}
-
-			os.Exit(1)
-		}
-		defer f.Close()
-
-		contents, err := io.ReadAll(f)
-		if err != nil {
-			fmt.Printf("could not read %s, database might be in the incomplete state", f.Name())
-			fmt.Println(err)
-
-			fmt.Println("rolling back")
-			err := tx.Rollback()
-			if err != nil {
-				fmt.Println(err)
-			}
-
-			os.Exit(1)
-		}
-
-		fmt.Printf("executing %s", f.Name())
-		_, err = tx.Exec(string(contents))
-		if err != nil {
-			fmt.Println(": ERROR")
-			fmt.Println(err)
-
-			fmt.Println("rolling back")
-			err := tx.Rollback()
-			if err != nil {
-				fmt.Println(err)
-			}
+	fmt.Println("db is up to date")
+}
 
-			os.Exit(1)
-		}
+// RollbackDB reverts the last n migrations
+func RollbackDB(fp string, fsys fs.FS, n int) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		fmt.Printf("could not load migrations: %v\n", err)
+		os.Exit(1)
+	}
 
-		fmt.Println(": OK")
+	db, err := openDB(fp)
+	if err != nil {
+		fmt.Printf("could not connect to db: %v\n", err)
+		os.Exit(1)
 	}
+	defer db.Close()
+
+	err = Rollback(db, migrations, n, func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	})
 
-	err = tx.Commit()
 	if err != nil {
-		fmt.Printf("db.Commit(): %v\n", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 }
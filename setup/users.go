@@ -0,0 +1,48 @@
+package setup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/valueof/bland/data"
+)
+
+// AddUser creates a user, or resets their password if they already exist,
+// reading the password from the first line of in
+func AddUser(dbp, name string, in io.Reader) {
+	err := data.ConnectToDB(dbp)
+	if err != nil {
+		fmt.Printf("couldn't connect to db: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("password for %s: ", name)
+	password, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Printf("couldn't read password: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+
+	tx, err := data.BeginTx(context.Background())
+	if err != nil {
+		os.Exit(1)
+	}
+
+	_, err = tx.AddUser(name, strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		fmt.Printf("couldn't add user: %v\n", err)
+		tx.Rollback()
+		os.Exit(1)
+	}
+
+	if err := tx.Commit(); err != nil {
+		os.Exit(1)
+	}
+
+	fmt.Printf("user %s can now log in\n", name)
+}
@@ -0,0 +1,4 @@
+drop trigger if exists bookmarks_fts_insert;
+drop trigger if exists bookmarks_fts_delete;
+drop trigger if exists bookmarks_fts_update;
+drop table if exists bookmarks_fts;
@@ -0,0 +1,19 @@
+create table if not exists bookmark_revisions (
+    id          integer primary key,
+    bookmark_id integer not null,
+    url         text,
+    title       text,
+    shortcut    text,
+    description text,
+    tags        text,
+    created_at  integer,
+
+    foreign key (bookmark_id) references bookmarks (id)
+);
+
+create index if not exists idx_bookmark_revisions_bookmark_id on bookmark_revisions (bookmark_id);
+
+-- Start every existing bookmark's history with its current state
+insert into bookmark_revisions (bookmark_id, url, title, shortcut, description, tags, created_at)
+select id, url, title, shortcut, description, tags, updated_at
+from bookmarks;
@@ -0,0 +1,6 @@
+create table if not exists users (
+    id            integer primary key,
+    name          text not null unique,
+    password_hash text not null,
+    created_at    integer
+);
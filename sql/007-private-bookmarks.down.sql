@@ -0,0 +1 @@
+alter table bookmarks drop column is_private;
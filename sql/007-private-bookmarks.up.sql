@@ -0,0 +1 @@
+alter table bookmarks add column is_private integer not null default 0;
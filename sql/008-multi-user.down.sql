@@ -0,0 +1,5 @@
+drop index if exists idx_tags_user_id_name;
+drop index if exists idx_bookmarks_user_id;
+
+alter table tags drop column user_id;
+alter table bookmarks drop column user_id;
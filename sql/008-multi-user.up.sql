@@ -0,0 +1,11 @@
+alter table bookmarks add column user_id integer not null default 0;
+alter table tags add column user_id integer not null default 0;
+
+-- Everything saved so far belongs to the first user. Bookmarks saved before
+-- there were any users keep user_id 0 until someone claims them (see
+-- Tx.AddUser).
+update bookmarks set user_id = coalesce((select min(id) from users), 0);
+update tags set user_id = coalesce((select min(id) from users), 0);
+
+create index if not exists idx_bookmarks_user_id on bookmarks (user_id, created_at);
+create index if not exists idx_tags_user_id_name on tags (user_id, name);
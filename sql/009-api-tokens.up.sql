@@ -0,0 +1,13 @@
+create table if not exists api_tokens (
+    id           integer primary key,
+    user_id      integer not null,
+    name         text not null,
+    token_hash   text not null unique,
+    scopes       text not null,
+    created_at   integer,
+    last_used_at integer not null default 0,
+
+    foreign key (user_id) references users (id)
+);
+
+create index if not exists idx_api_tokens_user_id on api_tokens (user_id);
@@ -0,0 +1,9 @@
+-- Icons are shared by everyone who bookmarked something on the same host.
+-- Hosts without an icon get a row with empty data so that they're only
+-- tried again once in a while.
+create table if not exists favicons (
+    host         text primary key,
+    content_type text not null default '',
+    data         blob,
+    fetched_at   integer not null
+);
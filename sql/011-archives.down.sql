@@ -0,0 +1,4 @@
+alter table users drop column archive_quota;
+
+drop index if exists idx_archives_status;
+drop table if exists archives;
@@ -0,0 +1,22 @@
+-- One archived copy per bookmark. data, size and archived_at describe the
+-- last copy that was saved successfully, status and error the last
+-- attempt, so a failed re-archive doesn't lose the previous copy.
+create table if not exists archives (
+    bookmark_id  integer primary key,
+    user_id      integer not null,
+    status       text not null,
+    url          text not null default '',
+    data         blob,
+    size         integer not null default 0,
+    error        text not null default '',
+    requested_at integer not null,
+    archived_at  integer not null default 0,
+
+    foreign key (bookmark_id) references bookmarks (id)
+);
+
+create index if not exists idx_archives_status on archives (status);
+
+-- How many bytes of compressed archives each user can keep, 100MB unless
+-- they change it in their settings
+alter table users add column archive_quota integer not null default 104857600;
@@ -0,0 +1,37 @@
+drop trigger if exists articles_fts_insert;
+drop trigger if exists articles_fts_delete;
+drop trigger if exists articles_fts_update;
+drop trigger if exists bookmarks_fts_insert;
+drop trigger if exists bookmarks_fts_delete;
+drop trigger if exists bookmarks_fts_update;
+drop table if exists bookmarks_fts;
+drop view if exists bookmarks_search;
+drop table if exists articles;
+
+create virtual table if not exists bookmarks_fts using fts5 (
+    url,
+    title,
+    description,
+    tags,
+    content = 'bookmarks',
+    content_rowid = 'id'
+);
+
+create trigger if not exists bookmarks_fts_insert after insert on bookmarks begin
+    insert into bookmarks_fts (rowid, url, title, description, tags)
+    values (new.id, new.url, new.title, new.description, new.tags);
+end;
+
+create trigger if not exists bookmarks_fts_delete after delete on bookmarks begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags)
+    values ('delete', old.id, old.url, old.title, old.description, old.tags);
+end;
+
+create trigger if not exists bookmarks_fts_update after update of url, title, description, tags on bookmarks begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags)
+    values ('delete', old.id, old.url, old.title, old.description, old.tags);
+    insert into bookmarks_fts (rowid, url, title, description, tags)
+    values (new.id, new.url, new.title, new.description, new.tags);
+end;
+
+insert into bookmarks_fts (bookmarks_fts) values ('rebuild');
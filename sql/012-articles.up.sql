@@ -0,0 +1,78 @@
+-- The readable text of bookmarked pages. Pages that didn't have any keep a
+-- row with an error so that they aren't fetched again.
+create table if not exists articles (
+    bookmark_id  integer primary key,
+    url          text not null default '',
+    content      text not null default '',
+    text         text not null default '',
+    word_count   integer not null default 0,
+    error        text not null default '',
+    extracted_at integer not null,
+
+    foreign key (bookmark_id) references bookmarks (id)
+);
+
+-- The search index gains a column for the article text, so it's rebuilt on
+-- top of a view that joins it to the bookmark. Changes to either table have
+-- to remove exactly what was indexed before adding the new values.
+drop trigger if exists bookmarks_fts_insert;
+drop trigger if exists bookmarks_fts_delete;
+drop trigger if exists bookmarks_fts_update;
+drop table if exists bookmarks_fts;
+
+create view if not exists bookmarks_search as
+select b.id, b.url, b.title, b.description, b.tags, coalesce(a.text, '') as text
+from bookmarks b
+left join articles a on a.bookmark_id = b.id;
+
+create virtual table if not exists bookmarks_fts using fts5 (
+    url,
+    title,
+    description,
+    tags,
+    text,
+    content = 'bookmarks_search',
+    content_rowid = 'id'
+);
+
+create trigger if not exists bookmarks_fts_insert after insert on bookmarks begin
+    insert into bookmarks_fts (rowid, url, title, description, tags, text)
+    select id, url, title, description, tags, text from bookmarks_search where id = new.id;
+end;
+
+create trigger if not exists bookmarks_fts_delete after delete on bookmarks begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags, text)
+    values ('delete', old.id, old.url, old.title, old.description, old.tags,
+        coalesce((select text from articles where bookmark_id = old.id), ''));
+end;
+
+create trigger if not exists bookmarks_fts_update after update of url, title, description, tags on bookmarks begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags, text)
+    values ('delete', old.id, old.url, old.title, old.description, old.tags,
+        coalesce((select text from articles where bookmark_id = old.id), ''));
+    insert into bookmarks_fts (rowid, url, title, description, tags, text)
+    select id, url, title, description, tags, text from bookmarks_search where id = new.id;
+end;
+
+create trigger if not exists articles_fts_insert after insert on articles begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags, text)
+    select 'delete', id, url, title, description, tags, '' from bookmarks where id = new.bookmark_id;
+    insert into bookmarks_fts (rowid, url, title, description, tags, text)
+    select id, url, title, description, tags, new.text from bookmarks where id = new.bookmark_id;
+end;
+
+create trigger if not exists articles_fts_delete after delete on articles begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags, text)
+    select 'delete', id, url, title, description, tags, old.text from bookmarks where id = old.bookmark_id;
+    insert into bookmarks_fts (rowid, url, title, description, tags, text)
+    select id, url, title, description, tags, '' from bookmarks where id = old.bookmark_id;
+end;
+
+create trigger if not exists articles_fts_update after update of text on articles begin
+    insert into bookmarks_fts (bookmarks_fts, rowid, url, title, description, tags, text)
+    select 'delete', id, url, title, description, tags, old.text from bookmarks where id = old.bookmark_id;
+    insert into bookmarks_fts (rowid, url, title, description, tags, text)
+    select id, url, title, description, tags, new.text from bookmarks where id = new.bookmark_id;
+end;
+
+insert into bookmarks_fts (bookmarks_fts) values ('rebuild');
@@ -0,0 +1,5 @@
+alter table users drop column is_admin;
+
+drop index if exists idx_jobs_active_key;
+drop index if exists idx_jobs_status_run_at;
+drop table if exists jobs;
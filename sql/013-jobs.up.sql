@@ -0,0 +1,34 @@
+-- Work that's done in the background, such as fetching pages. key
+-- identifies what a job works on, e.g. a bookmark id, so the same work
+-- isn't queued twice while it's waiting or running.
+create table if not exists jobs (
+    id           integer primary key,
+    user_id      integer not null default 0,
+    kind         text not null,
+    key          text not null default '',
+    payload      text not null default '{}',
+    status       text not null,
+    priority     integer not null default 0,
+    attempts     integer not null default 0,
+    max_attempts integer not null default 1,
+    run_at       integer not null,
+    last_error   text not null default '',
+    result       text not null default '',
+    created_at   integer not null,
+    updated_at   integer not null
+);
+
+create index if not exists idx_jobs_status_run_at on jobs (status, priority, run_at);
+
+create unique index if not exists idx_jobs_active_key on jobs (kind, key)
+where key <> '' and status in ('pending', 'running');
+
+-- Archives that were requested before there was a queue
+insert into jobs (user_id, kind, key, payload, status, max_attempts, run_at, created_at, updated_at)
+select user_id, 'archive', bookmark_id, json_object('bookmarkId', bookmark_id), 'pending', 5, requested_at, requested_at, requested_at
+from archives
+where status = 'pending';
+
+-- Admins can see the job queue. The first user is one.
+alter table users add column is_admin integer not null default 0;
+update users set is_admin = 1 where id = (select min(id) from users);
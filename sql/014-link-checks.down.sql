@@ -0,0 +1,6 @@
+drop index if exists idx_bookmarks_checked_at;
+
+alter table bookmarks drop column checked_at;
+alter table bookmarks drop column link_error;
+alter table bookmarks drop column link_url;
+alter table bookmarks drop column link_status;
@@ -0,0 +1,9 @@
+-- What the link checker found the last time it requested the URL of a
+-- bookmark: the status of the response, where redirects ended up, or why
+-- there was no response at all
+alter table bookmarks add column link_status integer not null default 0;
+alter table bookmarks add column link_url text not null default '';
+alter table bookmarks add column link_error text not null default '';
+alter table bookmarks add column checked_at integer not null default 0;
+
+create index if not exists idx_bookmarks_checked_at on bookmarks (checked_at);
@@ -0,0 +1,2 @@
+-- The rebuilt links are what they should have been all along, so there's
+-- nothing to undo
@@ -0,0 +1,33 @@
+-- Editing a bookmark used to drop its links to its tags, which renaming and
+-- merging tags go through, so they're rebuilt from the tags column of every
+-- bookmark. Tags that are missing are created and ones that nothing uses
+-- any more are deleted, like Tx.purge does.
+create temporary table bookmark_tags as
+with recursive split (bookmark_id, user_id, name, rest) as (
+    select id, user_id, '', trim(coalesce(tags, '')) || ' '
+    from bookmarks
+    union all
+    select bookmark_id, user_id,
+        substr(rest, 1, instr(rest, ' ') - 1),
+        ltrim(substr(rest, instr(rest, ' ') + 1))
+    from split
+    where rest <> ''
+)
+select distinct bookmark_id, user_id, name
+from split
+where name <> '';
+
+insert into tags (name, is_author, user_id)
+select distinct bt.name, substr(bt.name, 1, 3) = 'by:', bt.user_id
+from bookmark_tags bt
+where not exists (select 1 from tags t where t.name = bt.name and t.user_id = bt.user_id);
+
+delete from tags_bookmarks;
+
+insert into tags_bookmarks (bookmark_id, tag_id)
+select bt.bookmark_id, (select min(t.id) from tags t where t.name = bt.name and t.user_id = bt.user_id)
+from bookmark_tags bt;
+
+delete from tags where id not in (select tag_id from tags_bookmarks);
+
+drop table bookmark_tags;
@@ -0,0 +1,3 @@
+-- Set when a job is queued again while it's running, e.g. because the URL
+-- of its bookmark changed, so that it runs once more after it's done
+alter table jobs add column rerun integer not null default 0;
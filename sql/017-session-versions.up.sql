@@ -0,0 +1,3 @@
+-- Session cookies carry the version they were issued for. Bumping it on
+-- logout or a password change invalidates every cookie handed out before.
+alter table users add column session_version integer not null default 0;
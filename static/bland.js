@@ -19,9 +19,27 @@ function main() {
             case "delete-bookmark":
                 deleteBookmark(ev)
                 break
+            case "restore-bookmark":
+                restoreBookmark(ev)
+                break
+            case "purge-bookmark":
+                purgeBookmark(ev)
+                break
+            case "archive-bookmark":
+                archiveBookmark(ev)
+                break
+            case "use-redirect":
+                useRedirect(ev)
+                break
+            case "empty-trash":
+                emptyTrash(ev)
+                break
             case "fetch-metadata":
                 fetchMetadata(ev)
                 break
+            case "add-tag":
+                addTag(ev)
+                break
             default:
                 console.error("unsupported action:", action)
         }
@@ -30,6 +48,32 @@ function main() {
     })
 }
 
+/** helpers */
+
+// post sends a POST request with the page's CSRF token attached, which the
+// server requires for every request that changes data
+function post(path, body) {
+    const meta = document.querySelector("meta[name=csrf-token]")
+    return fetch(path, {
+        method: "POST",
+        body: body,
+        headers: {"X-CSRF-Token": meta ? meta.content : ""},
+    })
+}
+
+// patch sends a PATCH request with a JSON body, see post
+function patch(path, body) {
+    const meta = document.querySelector("meta[name=csrf-token]")
+    return fetch(path, {
+        method: "PATCH",
+        body: JSON.stringify(body),
+        headers: {
+            "Content-Type": "application/json",
+            "X-CSRF-Token": meta ? meta.content : "",
+        },
+    })
+}
+
 /** actions */
 
 async function markAsRead(ev) {
@@ -41,7 +85,7 @@ async function markAsRead(ev) {
         return
     }
 
-    const resp = await fetch('/api/mark-read', {method: "POST", body: id})
+    const resp = await post('/api/mark-read', id)
     if (!resp.ok) {
         return
     }
@@ -69,7 +113,7 @@ async function deleteBookmark(ev) {
         return
     }
 
-    const resp = await fetch('/api/delete-bookmark', {method: "POST", body: id})
+    const resp = await post('/api/delete-bookmark', id)
     if (!resp.ok) {
         return
     }
@@ -85,6 +129,99 @@ async function deleteBookmark(ev) {
     }, 2000)
 }
 
+// useRedirect changes the URL of a bookmark to where it redirects to
+async function useRedirect(ev) {
+    const target = ev.target
+    const id = target.getAttribute("data-id")
+    const url = target.getAttribute("data-url")
+    if (!id || !url) {
+        console.error("called useRedirect without id or url")
+        return
+    }
+
+    const resp = await patch(`/api/bookmarks/${id}`, {url: url})
+    if (!resp.ok) {
+        return
+    }
+
+    replaceBookmark(id, "bookmark updated!")
+}
+
+function replaceBookmark(id, message) {
+    const bookmark = document.querySelector(`#bookmark-${id}`)
+    const replacement = document.createElement("div")
+    replacement.className = "bookmarks--bookmark u-pill"
+    replacement.innerHTML = message
+    bookmark.parentNode.replaceChild(replacement, bookmark)
+
+    setTimeout(() => {
+        replacement.parentNode.removeChild(replacement)
+    }, 2000)
+}
+
+async function restoreBookmark(ev) {
+    const id = ev.target.getAttribute("data-id")
+    if (!id) {
+        console.error("called restoreBookmark without id")
+        return
+    }
+
+    const resp = await post('/api/restore-bookmark', id)
+    if (!resp.ok) {
+        return
+    }
+
+    replaceBookmark(id, "bookmark restored!")
+}
+
+async function purgeBookmark(ev) {
+    const id = ev.target.getAttribute("data-id")
+    if (!id) {
+        console.error("called purgeBookmark without id")
+        return
+    }
+
+    if (!confirm("Delete this bookmark forever? This can't be undone.")) {
+        return
+    }
+
+    const resp = await post('/api/purge-bookmark', id)
+    if (!resp.ok) {
+        return
+    }
+
+    replaceBookmark(id, "bookmark deleted forever!")
+}
+
+async function archiveBookmark(ev) {
+    const target = ev.target
+    const id = target.getAttribute("data-id")
+    if (!id) {
+        console.error("called archiveBookmark without id")
+        return
+    }
+
+    const resp = await post(`/api/bookmarks/${id}/archive`)
+    if (!resp.ok) {
+        return
+    }
+
+    target.parentNode.replaceChild(document.createTextNode("archiving..."), target)
+}
+
+async function emptyTrash() {
+    if (!confirm("Delete everything in the trash forever? This can't be undone.")) {
+        return
+    }
+
+    const resp = await post('/api/empty-trash')
+    if (!resp.ok) {
+        return
+    }
+
+    window.location.reload()
+}
+
 async function fetchMetadata() {
     const url = document.querySelector("#url")
     const title = document.querySelector("#title")
@@ -94,13 +231,15 @@ async function fetchMetadata() {
         return
     }
 
-    const path = '/api/fetch-metadata?u=' + encodeURIComponent(url.value)
-    const resp = await fetch(path, {method: "GET"})
-    if (!resp.ok) {
+    const data = await awaitMetadata(new URLSearchParams({u: url.value}))
+    if (!data) {
         return
     }
 
-    const data = await resp.json()
+    if (data.url) {
+        url.value = data.url
+    }
+
     if (title && title.value == "") {
         title.value = data.title
     }
@@ -108,4 +247,94 @@ async function fetchMetadata() {
     if (desc && desc.value == "") {
         desc.value = data.description
     }
-}
\ No newline at end of file
+
+    suggestTags(data.tags || [])
+}
+
+// awaitMetadata fetches the metadata of a page. Slow pages are fetched in
+// the background, in which case the server answers with a job to poll.
+async function awaitMetadata(body) {
+    const resp = await post("/api/fetch-metadata", body)
+    if (resp.status == 202) {
+        return await awaitJob(resp.headers.get("Location"))
+    }
+
+    if (!resp.ok) {
+        return null
+    }
+
+    return await resp.json()
+}
+
+// awaitJob polls a background job for up to half a minute and returns its
+// result, or null if it failed or is still not done
+async function awaitJob(path) {
+    for (let tries = 0; tries < 30; tries++) {
+        await new Promise((resolve) => setTimeout(resolve, 1000))
+
+        const resp = await fetch(path, {method: "GET"})
+        if (!resp.ok) {
+            return null
+        }
+
+        const job = await resp.json()
+        if (job.status == "done") {
+            return job.result
+        } else if (job.status == "failed") {
+            return null
+        }
+    }
+
+    return null
+}
+
+function currentTags() {
+    const tags = document.querySelector("#tags")
+    return tags ? tags.value.split(" ").filter((t) => t != "") : []
+}
+
+// suggestTags shows tags found in the page's metadata below the tags
+// field, clicking one adds it
+function suggestTags(suggestions) {
+    const row = document.querySelector("#tag-suggestions")
+    if (!row) {
+        return
+    }
+
+    const current = currentTags()
+    row.replaceChildren()
+    for (const tag of suggestions) {
+        if (current.includes(tag)) {
+            continue
+        }
+
+        const btn = document.createElement("button")
+        btn.className = "btn--link"
+        btn.setAttribute("data-action", "add-tag")
+        btn.setAttribute("data-tag", tag)
+        btn.textContent = tag
+        row.appendChild(btn)
+    }
+
+    row.hidden = row.children.length == 0
+}
+
+function addTag(ev) {
+    const tags = document.querySelector("#tags")
+    const tag = ev.target.getAttribute("data-tag")
+    if (!tags || !tag) {
+        return
+    }
+
+    const current = currentTags()
+    if (!current.includes(tag)) {
+        current.push(tag)
+        tags.value = current.join(" ")
+    }
+
+    ev.target.remove()
+    const row = document.querySelector("#tag-suggestions")
+    if (row && row.children.length == 0) {
+        row.hidden = true
+    }
+}